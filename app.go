@@ -3,21 +3,28 @@ package main
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	// "os"
 	"os/exec"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/getlantern/systray"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 	"switcher/library"
+	"switcher/stats"
 )
 
 // App struct
 type App struct {
-	ctx       context.Context
-	config    Config
-	library   *library.Library
+	ctx     context.Context
+	config  Config
+	library *library.Library
+
+	scanMu     sync.Mutex
+	scanCancel context.CancelFunc
+	scanDone   chan struct{}
 }
 
 //go:embed assets/letter-s.png
@@ -49,23 +56,40 @@ func NewApp() *App {
 		config: config,
 	}
 
-	// Initialize the library and scan books
+	// Initialize the library, books are scanned in the background once the
+	// window is up
+	extractor, err := library.NewExtractorChain(config.Metadata.Extractors, config.Metadata.Formats)
+	if err != nil {
+		fmt.Printf("Invalid metadata extractor configuration, using defaults: %v\n", err)
+		extractor = library.DefaultExtractorChain()
+	}
+
+	sources, err := library.NewProgressSources(config.ProgressSources())
+	if err != nil {
+		fmt.Printf("Invalid progress sources, reading every installed reader: %v\n", err)
+		sources, _ = library.NewProgressSources(nil)
+	}
+
 	libraryDbPath, err := library.GetLibraryDatabasePath()
 	if err == nil {
-		lib, err := library.NewLibrary(libraryDbPath)
+		lib, err := library.NewLibrary(libraryDbPath, extractor, sources)
 		if err == nil {
 			app.library = lib
-			
-			// Scan books directory with timing
-			fmt.Printf("Starting book library scan from: %s\n", config.General.BookScanPath)
-			startTime := time.Now()
-			
-			err = lib.ScanDirectory(config.General.BookScanPath)
-			if err != nil {
-				fmt.Printf("Failed to scan books directory: %v\n", err)
-			} else {
-				elapsed := time.Since(startTime)
-				fmt.Printf("Book library scan completed in: %v\n", elapsed)
+			lib.ScanWorkers = config.General.ScanWorkers
+			lib.IgnorePatterns = config.General.Ignore
+			lib.FrecencyHalfLife = time.Duration(config.General.FrecencyHalfLifeDays * float64(24*time.Hour))
+			// Invalid roots are left out, scanning them could drop their books
+			for _, root := range config.LibraryRoots() {
+				if err := library.ValidateRoots([]library.Root{root}); err != nil {
+					fmt.Printf("Ignoring invalid library root: %v\n", err)
+					continue
+				}
+				lib.Roots = append(lib.Roots, root)
+			}
+			lib.Openers = config.LibraryOpeners()
+			if err := library.ValidateOpeners(lib.Openers); err != nil {
+				fmt.Printf("Invalid openers, using the default ones: %v\n", err)
+				lib.Openers = nil
 			}
 		} else {
 			fmt.Printf("Failed to create library: %v\n", err)
@@ -86,6 +110,32 @@ func (a *App) startup(ctx context.Context) {
 		time.Sleep(500 * time.Millisecond)
 		systray.Run(onReady, onExit)
 	}()
+	a.startScan()
+	a.watchLibrary(ctx)
+	if a.library != nil {
+		if err := a.library.ResumeSessions(ctx); err != nil {
+			fmt.Printf("Failed to resume reading sessions: %v\n", err)
+		}
+	}
+}
+
+// watchLibrary keeps the library in sync with its roots and tells the
+// frontend to refresh whenever books are added, changed or removed
+func (a *App) watchLibrary(ctx context.Context) {
+	if a.library == nil {
+		return
+	}
+
+	watcher, err := a.library.NewWatcher(a.library.Roots)
+	if err != nil {
+		fmt.Printf("Failed to watch books directory: %v\n", err)
+		return
+	}
+	watcher.OnChange = func(event library.WatchEvent) {
+		fmt.Printf("Library changed: %d added, %d updated, %d removed\n", event.Added, event.Updated, event.Removed)
+		runtime.EventsEmit(ctx, "library:changed", event)
+	}
+	go watcher.Run(ctx)
 }
 
 // shutdown is called when the app is closing
@@ -127,36 +177,38 @@ func (a *App) ExecCommand(cmd string) error {
 }
 
 // RecreateLibrary drops and rescans the book library
-func (a *App) RecreateLibrary() error {
+func (a *App) RecreateLibrary() (library.ScanReport, error) {
 	if a.library == nil {
-		return fmt.Errorf("library not initialized")
+		return library.ScanReport{}, fmt.Errorf("library not initialized")
 	}
 
+	// Stop a scan in progress, its results are about to be dropped anyway
+	a.cancelScanAndWait()
+
 	if err := a.library.ResetDatabase(); err != nil {
-		return fmt.Errorf("failed to reset database: %w", err)
+		return library.ScanReport{}, fmt.Errorf("failed to reset database: %w", err)
 	}
 
-	// Scan books directory with timing
-	fmt.Printf("Starting book library scan from: %s\n", a.config.General.BookScanPath)
-	startTime := time.Now()
-
-	err := a.library.ScanDirectory(a.config.General.BookScanPath)
+	report, err := a.runScan()
 	if err != nil {
-		fmt.Printf("Failed to scan books directory: %v\n", err)
-		return fmt.Errorf("failed to scan directory: %w", err)
+		return report, fmt.Errorf("failed to scan directory: %w", err)
 	}
-
-	elapsed := time.Since(startTime)
-	fmt.Printf("Book library scan completed in: %v\n", elapsed)
-	return nil
+	return report, nil
 }
 
+// GetBooks returns the books matching a search query such as
+// `author:strugatsky format:fb2 unread`. A malformed query fails with an
+// error starting with "invalid query:" that the UI shows as is
 func (a *App) GetBooks(searchTerm string) ([]library.Book, error) {
 	if a.library == nil {
 		return nil, fmt.Errorf("library not initialized")
 	}
 
 	books, err := a.library.SearchBooks(searchTerm)
+	var queryErr *library.QueryError
+	if errors.As(err, &queryErr) {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to search books: %w", err)
 	}
@@ -164,27 +216,95 @@ func (a *App) GetBooks(searchTerm string) ([]library.Book, error) {
 	return books, nil
 }
 
+// SearchBooksDetailed is GetBooks with the fields and rune ranges that
+// matched each book, so the UI can highlight them
+func (a *App) SearchBooksDetailed(searchTerm string) ([]library.SearchResult, error) {
+	if a.library == nil {
+		return nil, fmt.Errorf("library not initialized")
+	}
+
+	results, err := a.library.SearchBooksDetailed(searchTerm)
+	var queryErr *library.QueryError
+	if errors.As(err, &queryErr) {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to search books: %w", err)
+	}
+
+	return results, nil
+}
+
 // Hide the switcher window by moving it to workspace 9
 func (a *App) Hide() error {
 	cmd := exec.Command("hyprctl", "dispatch", "movetoworkspacesilent", "9,title:switcher")
 	return cmd.Run()
 }
 
-func (a *App) OpenBook(filePath string) error {
+func (a *App) OpenBook(filePath string) (library.OpenResult, error) {
+	return a.OpenBookWith(filePath, "")
+}
+
+// OpenBookWith opens a book with the opener of that name, or the first one
+// that applies to it and is installed when name is empty. The result tells
+// where reading resumes
+func (a *App) OpenBookWith(filePath, opener string) (library.OpenResult, error) {
+	if a.library == nil {
+		return library.OpenResult{}, fmt.Errorf("library not initialized")
+	}
+	cmd, result, err := a.library.OpenCommand(filePath, opener)
+	if err != nil {
+		return library.OpenResult{}, err
+	}
+
 	a.Hide()
-	if strings.HasSuffix(strings.ToLower(filePath), ".pdf") {
-		cmd := exec.Command("zathura", filePath)
-		err := cmd.Start()
-		if err != nil {
-			return fmt.Errorf("failed to start zathura for %s: %w", filePath, err)
-		}
-		return nil
-	} else {
-		cmd := exec.Command("foliate", filePath)
-		err := cmd.Start()
-		if err != nil {
-			return fmt.Errorf("failed to start foliate for %s: %w", filePath, err)
-		}
-		return nil
+	if err := cmd.Start(); err != nil {
+		return library.OpenResult{}, fmt.Errorf("failed to start %s for %s: %w", cmd.Path, filePath, err)
+	}
+	if err := a.library.RecordOpen(filePath); err != nil {
+		fmt.Printf("Failed to record opening %s: %v\n", filePath, err)
+	}
+	if err := a.library.TrackSession(cmd, result, filePath); err != nil {
+		fmt.Printf("Failed to record reading session of %s: %v\n", filePath, err)
+	}
+	return result, nil
+}
+
+// GetReadingStats returns reading statistics for the days from rangeStart
+// to rangeEnd, as YYYY-MM-DD in the local time zone. Empty dates mean the
+// last 30 days
+func (a *App) GetReadingStats(rangeStart, rangeEnd string) (*stats.Report, error) {
+	if a.library == nil {
+		return nil, fmt.Errorf("library not initialized")
+	}
+	from, to, err := stats.ParseRange(rangeStart, rangeEnd, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return stats.Compute(a.library.DB, from, to, time.Local)
+}
+
+// GetReadingSessions returns the times a book was read, the latest first
+func (a *App) GetReadingSessions(bookPath string) ([]library.ReadingSession, error) {
+	if a.library == nil {
+		return nil, fmt.Errorf("library not initialized")
+	}
+	return a.library.GetReadingSessions(bookPath)
+}
+
+// GetOpeners returns the names of the openers that can open a book, the one
+// OpenBook uses first
+func (a *App) GetOpeners(filePath string) ([]string, error) {
+	if a.library == nil {
+		return nil, fmt.Errorf("library not initialized")
+	}
+	book, err := a.library.Book(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, opener := range a.library.OpenersFor(book) {
+		names = append(names, opener.Name)
 	}
+	return names, nil
 }
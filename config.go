@@ -1,10 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+	"switcher/library"
 )
 
 // Command struct represents a command that can be executed
@@ -17,12 +20,66 @@ type Command struct {
 // General represents general application settings
 type General struct {
 	BookScanPath string `toml:"book_scan_path"`
+	// ScanWorkers is the number of books read in parallel during a scan
+	ScanWorkers int `toml:"scan_workers"`
+	// Ignore holds gitignore-style patterns skipped in every scan root
+	Ignore []string `toml:"ignore"`
+	// FrecencyHalfLifeDays is how many days it takes for opening a book to
+	// count half as much in search ranking
+	FrecencyHalfLifeDays float64 `toml:"frecency_half_life_days"`
+}
+
+// Metadata selects the extractors used to read book metadata, in order.
+// Formats overrides the order for single formats, e.g. pdf = ["pdf", "filename"]
+type Metadata struct {
+	Extractors []string            `toml:"extractors"`
+	Formats    map[string][]string `toml:"formats"`
+}
+
+// LibraryRoot is one [[library.roots]] entry, a directory tree scanned for
+// books
+type LibraryRoot struct {
+	Path    string   `toml:"path"`
+	Label   string   `toml:"label"`
+	Formats []string `toml:"formats"`
+	Ignore  []string `toml:"ignore"`
+	// Removable roots may be offline, their books are kept meanwhile
+	Removable bool `toml:"removable"`
+}
+
+// LibraryConfig lists the library roots. Without roots, BookScanPath is
+// scanned on its own
+type LibraryConfig struct {
+	Roots []LibraryRoot `toml:"roots"`
+}
+
+// SourceConfig is a [sources.<name>] table. Every installed reader is a
+// source of reading progress unless it is disabled
+type SourceConfig struct {
+	Enabled *bool `toml:"enabled"`
+	// Path overrides where the reader keeps its data
+	Path string `toml:"path"`
+}
+
+// Opener is one [[openers]] entry, a command template for the books that
+// match its formats, globs and roots
+type Opener struct {
+	Name      string   `toml:"name"`
+	Run       string   `toml:"run"`
+	Fallbacks []string `toml:"fallbacks"`
+	Formats   []string `toml:"formats"`
+	Globs     []string `toml:"globs"`
+	Roots     []string `toml:"roots"`
 }
 
 // Config represents the application configuration
 type Config struct {
-	General  General            `toml:"general"`
-	Commands map[string]Command `toml:"commands"`
+	General  General                 `toml:"general"`
+	Library  LibraryConfig           `toml:"library"`
+	Metadata Metadata                `toml:"metadata"`
+	Sources  map[string]SourceConfig `toml:"sources"`
+	Openers  []Opener                `toml:"openers"`
+	Commands map[string]Command      `toml:"commands"`
 }
 
 // LoadConfig loads the configuration from the TOML file
@@ -38,17 +95,93 @@ func LoadConfig() (Config, error) {
 
 	configPath := filepath.Join(home, ".config", "switcher", "switcher.toml")
 
+	// Set default book scan path, also used without a config file
+	defaultScanPath := filepath.Join(home, "pCloudDrive")
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		config.General.BookScanPath = defaultScanPath
 		return config, err
 	}
 
 	// Parse the TOML file
 	_, err = toml.DecodeFile(configPath, &config)
-	
+
 	// Set default book scan path if not specified
 	if config.General.BookScanPath == "" {
-		config.General.BookScanPath = filepath.Join(home, "pCloudDrive")
+		config.General.BookScanPath = defaultScanPath
+	}
+
+	for i, root := range config.Library.Roots {
+		config.Library.Roots[i].Path = expandHome(root.Path, home)
+	}
+	for i, opener := range config.Openers {
+		for j, root := range opener.Roots {
+			config.Openers[i].Roots[j] = expandHome(root, home)
+		}
+	}
+	for name, source := range config.Sources {
+		source.Path = expandHome(source.Path, home)
+		config.Sources[name] = source
 	}
-	
+
 	return config, err
 }
+
+// LibraryRoots returns the roots to scan, falling back to BookScanPath
+func (c Config) LibraryRoots() []library.Root {
+	if len(c.Library.Roots) == 0 {
+		return []library.Root{{Path: filepath.Clean(c.General.BookScanPath)}}
+	}
+	roots := make([]library.Root, 0, len(c.Library.Roots))
+	for _, root := range c.Library.Roots {
+		if root.Path == "" {
+			fmt.Printf("Ignoring library root %q without a path\n", root.Label)
+			continue
+		}
+		roots = append(roots, library.Root{
+			Path:      filepath.Clean(root.Path),
+			Label:     root.Label,
+			Formats:   root.Formats,
+			Ignore:    root.Ignore,
+			Removable: root.Removable,
+		})
+	}
+	return roots
+}
+
+// ProgressSources returns the [sources] tables for library.NewProgressSources
+func (c Config) ProgressSources() map[string]library.SourceConfig {
+	sources := make(map[string]library.SourceConfig, len(c.Sources))
+	for name, source := range c.Sources {
+		sources[name] = library.SourceConfig{
+			Disabled: source.Enabled != nil && !*source.Enabled,
+			Path:     source.Path,
+		}
+	}
+	return sources
+}
+
+// LibraryOpeners returns the [[openers]] for library.Library.Openers
+func (c Config) LibraryOpeners() []library.Opener {
+	openers := make([]library.Opener, 0, len(c.Openers))
+	for _, opener := range c.Openers {
+		openers = append(openers, library.Opener{
+			Name:      opener.Name,
+			Run:       opener.Run,
+			Fallbacks: opener.Fallbacks,
+			Formats:   opener.Formats,
+			Globs:     opener.Globs,
+			Roots:     opener.Roots,
+		})
+	}
+	return openers
+}
+
+func expandHome(path, home string) string {
+	if path == "~" {
+		return home
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(home, path[2:])
+	}
+	return path
+}
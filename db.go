@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"switcher/library"
+	"switcher/util"
+)
+
+const dbUsage = `Usage: switcher db <command>
+
+Commands:
+  status              show the schema version and pending migrations
+  migrate [--dry-run] apply pending migrations, backing up the database first
+`
+
+// runDbCommand handles `switcher db ...` and exits
+func runDbCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Print(dbUsage)
+		os.Exit(2)
+	}
+
+	dbPath, err := library.GetLibraryDatabasePath()
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	db, err := util.LoadDatabase(dbPath)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		status, err := library.GetSchemaStatus(db)
+		fmt.Printf("Database: %s\n", dbPath)
+		fmt.Printf("Schema version: %d (latest %d)\n", status.Version, status.Latest)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+		if len(status.Pending) == 0 {
+			fmt.Println("✅ Up to date")
+			return
+		}
+		fmt.Println("Pending migrations:")
+		for _, m := range status.Pending {
+			fmt.Printf("  %d: %s\n", m.Version, m.Name)
+		}
+
+	case "migrate":
+		flags := flag.NewFlagSet("db migrate", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "run the migrations in a transaction that is rolled back")
+		flags.Parse(args[1:])
+
+		version, err := library.SchemaVersion(db)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+		opts := library.MigrateOptions{DryRun: *dryRun, BackupPath: library.BackupPath(dbPath, version)}
+		applied, err := library.Migrate(db, opts)
+		for _, m := range applied {
+			if *dryRun {
+				fmt.Printf("Would apply %d: %s\n", m.Version, m.Name)
+			} else {
+				fmt.Printf("Applied %d: %s\n", m.Version, m.Name)
+			}
+		}
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+		switch {
+		case len(applied) == 0:
+			fmt.Println("✅ Up to date")
+		case *dryRun:
+			fmt.Println("✅ Dry run succeeded, nothing was changed")
+		default:
+			fmt.Printf("✅ Migrated to version %d\n", library.LatestSchemaVersion())
+		}
+
+	default:
+		fmt.Print(dbUsage)
+		os.Exit(2)
+	}
+}
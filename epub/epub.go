@@ -0,0 +1,292 @@
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Creator struct {
+	Name   string `json:"name"`
+	FileAs string `json:"fileAs,omitempty"`
+	Role   string `json:"role,omitempty"`
+}
+
+type Identifier struct {
+	Scheme string `json:"scheme,omitempty"`
+	Value  string `json:"value"`
+}
+
+type Metadata struct {
+	Title       string       `json:"title"`
+	Creators    []Creator    `json:"creators,omitempty"`
+	Language    string       `json:"language,omitempty"`
+	Identifiers []Identifier `json:"identifiers,omitempty"`
+	Publisher   string       `json:"publisher,omitempty"`
+	Subjects    []string     `json:"subjects,omitempty"`
+	Series      string       `json:"series,omitempty"`
+	SeriesIndex float64      `json:"seriesIndex,omitempty"`
+	Description string       `json:"description,omitempty"`
+}
+
+// Authors returns creators that are authors. Creators without a role are
+// treated as authors, which is what most EPUB 2 files rely on.
+func (m *Metadata) Authors() []Creator {
+	var authors []Creator
+	for _, c := range m.Creators {
+		if c.Role == "" || c.Role == "aut" {
+			authors = append(authors, c)
+		}
+	}
+	return authors
+}
+
+type container struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type element struct {
+	ID     string `xml:"id,attr"`
+	FileAs string `xml:"file-as,attr"`
+	Role   string `xml:"role,attr"`
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type meta struct {
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	ID       string `xml:"id,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type packageDocument struct {
+	Metadata struct {
+		Titles      []element `xml:"title"`
+		Creators    []element `xml:"creator"`
+		Languages   []element `xml:"language"`
+		Identifiers []element `xml:"identifier"`
+		Publishers  []element `xml:"publisher"`
+		Subjects    []element `xml:"subject"`
+		Description []element `xml:"description"`
+		Meta        []meta    `xml:"meta"`
+	} `xml:"metadata"`
+}
+
+func ReadMetadata(filePath string) (*Metadata, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening epub: %w", err)
+	}
+	defer r.Close()
+
+	var c container
+	if err := decodeEntry(&r.Reader, "META-INF/container.xml", &c); err != nil {
+		return nil, err
+	}
+
+	opfPath := ""
+	for _, rootfile := range c.Rootfiles {
+		if rootfile.MediaType == "" || rootfile.MediaType == "application/oebps-package+xml" {
+			opfPath = rootfile.FullPath
+			break
+		}
+	}
+	if opfPath == "" {
+		return nil, fmt.Errorf("no package document in container.xml")
+	}
+
+	var pkg packageDocument
+	if err := decodeEntry(&r.Reader, path.Clean(opfPath), &pkg); err != nil {
+		return nil, err
+	}
+
+	return parsePackage(&pkg), nil
+}
+
+func decodeEntry(r *zip.Reader, name string, v any) error {
+	var file *zip.File
+	for _, f := range r.File {
+		if f.Name == name {
+			file = f
+			break
+		}
+	}
+	// Some producers get the case of the path wrong
+	if file == nil {
+		for _, f := range r.File {
+			if strings.EqualFold(f.Name, name) {
+				file = f
+				break
+			}
+		}
+	}
+	if file == nil {
+		return fmt.Errorf("%s not found in epub", name)
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", name, err)
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(rc)
+	decoder.Strict = false
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// Package documents are required to be UTF-8, but some declare
+		// other names for it. Read them as is.
+		return input, nil
+	}
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("error parsing %s: %w", name, err)
+	}
+	return nil
+}
+
+func parsePackage(pkg *packageDocument) *Metadata {
+	md := pkg.Metadata
+
+	// EPUB 3 attaches properties to elements with <meta refines="#id">
+	refines := make(map[string]map[string]string)
+	for _, m := range md.Meta {
+		if m.Refines == "" || m.Property == "" {
+			continue
+		}
+		id := strings.TrimPrefix(m.Refines, "#")
+		if refines[id] == nil {
+			refines[id] = make(map[string]string)
+		}
+		refines[id][m.Property] = clean(m.Value)
+	}
+
+	metadata := &Metadata{}
+
+	for _, t := range md.Titles {
+		title := clean(t.Value)
+		if title == "" {
+			continue
+		}
+		titleType := refines[t.ID]["title-type"]
+		if metadata.Title == "" || titleType == "main" {
+			metadata.Title = title
+		}
+		if titleType == "main" {
+			break
+		}
+	}
+
+	for _, c := range md.Creators {
+		name := clean(c.Value)
+		if name == "" {
+			continue
+		}
+		creator := Creator{Name: name, FileAs: clean(c.FileAs), Role: clean(c.Role)}
+		if props, ok := refines[c.ID]; ok {
+			if creator.FileAs == "" {
+				creator.FileAs = props["file-as"]
+			}
+			if creator.Role == "" {
+				creator.Role = props["role"]
+			}
+		}
+		metadata.Creators = append(metadata.Creators, creator)
+	}
+
+	for _, lang := range md.Languages {
+		if value := clean(lang.Value); value != "" {
+			metadata.Language = value
+			break
+		}
+	}
+
+	for _, id := range md.Identifiers {
+		value := clean(id.Value)
+		if value == "" {
+			continue
+		}
+		scheme := clean(id.Scheme)
+		if scheme == "" {
+			scheme = refines[id.ID]["identifier-type"]
+		}
+		if scheme == "" {
+			// urn:isbn:..., urn:uuid:...
+			if rest, ok := strings.CutPrefix(strings.ToLower(value), "urn:"); ok {
+				if i := strings.Index(rest, ":"); i > 0 {
+					scheme = rest[:i]
+					value = value[len("urn:")+i+1:]
+				}
+			}
+		}
+		metadata.Identifiers = append(metadata.Identifiers, Identifier{Scheme: strings.ToLower(scheme), Value: value})
+	}
+
+	for _, p := range md.Publishers {
+		if value := clean(p.Value); value != "" {
+			metadata.Publisher = value
+			break
+		}
+	}
+
+	for _, s := range md.Subjects {
+		if value := clean(s.Value); value != "" {
+			metadata.Subjects = append(metadata.Subjects, value)
+		}
+	}
+
+	for _, d := range md.Description {
+		if value := stripTags(d.Value); value != "" {
+			metadata.Description = value
+			break
+		}
+	}
+
+	for _, m := range md.Meta {
+		switch {
+		case m.Name == "calibre:series":
+			metadata.Series = clean(m.Content)
+		case m.Name == "calibre:series_index":
+			metadata.SeriesIndex = parseIndex(m.Content)
+		case m.Property == "belongs-to-collection" && metadata.Series == "":
+			props := refines[m.ID]
+			if collectionType, ok := props["collection-type"]; ok && collectionType != "series" {
+				continue
+			}
+			metadata.Series = clean(m.Value)
+			metadata.SeriesIndex = parseIndex(props["group-position"])
+		}
+	}
+
+	return metadata
+}
+
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// Descriptions are frequently escaped HTML
+func stripTags(s string) string {
+	s = html.UnescapeString(tagPattern.ReplaceAllString(s, " "))
+	return clean(tagPattern.ReplaceAllString(s, " "))
+}
+
+func parseIndex(s string) float64 {
+	index, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0
+	}
+	return index
+}
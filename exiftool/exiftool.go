@@ -0,0 +1,280 @@
+package exiftool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session keeps a single exiftool process running with -stay_open so that
+// bulk scans do not pay Perl's startup cost for every file. It is safe for
+// concurrent use; requests are queued on the process stdin and matched to
+// their output by the number in -executeNNN.
+type Session struct {
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	writeMu sync.Mutex
+	proc    *process
+	nextID  int
+	closed  bool
+}
+
+type process struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	pending map[int]chan response
+	done    chan struct{}
+	// stderrDone is closed once stderr is drained; Wait closes the pipe
+	stderrDone chan struct{}
+}
+
+type response struct {
+	data []byte
+	err  error
+}
+
+var (
+	ErrClosed = errors.New("exiftool session closed")
+	errExited = errors.New("exiftool exited")
+)
+
+var readyPattern = regexp.MustCompile(`^\{ready(\d+)\}$`)
+
+// NewSession returns a session that starts exiftool on first use.
+func NewSession() *Session {
+	return &Session{Timeout: 30 * time.Second}
+}
+
+func (s *Session) start() (*process, error) {
+	path, err := exec.LookPath("exiftool")
+	if err != nil {
+		return nil, fmt.Errorf("exiftool is not installed: %w", err)
+	}
+
+	cmd := exec.Command(path, "-stay_open", "True", "-@", "-")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting exiftool: %w", err)
+	}
+
+	p := &process{
+		cmd:        cmd,
+		stdin:      stdin,
+		pending:    make(map[int]chan response),
+		done:       make(chan struct{}),
+		stderrDone: make(chan struct{}),
+	}
+	go s.readLoop(p, stdout)
+	go func() {
+		defer close(p.stderrDone)
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Printf("exiftool: %s", scanner.Text())
+		}
+	}()
+	return p, nil
+}
+
+func (s *Session) readLoop(p *process, stdout io.Reader) {
+	reader := bufio.NewReader(stdout)
+	var buf bytes.Buffer
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		if match := readyPattern.FindSubmatch(bytes.TrimSpace(line)); match != nil {
+			id, _ := strconv.Atoi(string(match[1]))
+			s.mu.Lock()
+			ch, ok := p.pending[id]
+			delete(p.pending, id)
+			s.mu.Unlock()
+			if ok {
+				ch <- response{data: bytes.Clone(buf.Bytes())}
+			}
+			buf.Reset()
+			continue
+		}
+		buf.Write(line)
+	}
+
+	<-p.stderrDone
+	err := p.cmd.Wait()
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+
+	// Fail everything still waiting; the next request starts a new process
+	s.mu.Lock()
+	for id, ch := range p.pending {
+		ch <- response{err: fmt.Errorf("%w: %w", errExited, err)}
+		delete(p.pending, id)
+	}
+	if s.proc == p {
+		s.proc = nil
+	}
+	s.mu.Unlock()
+	close(p.done)
+}
+
+// Query runs exiftool on a single file with -json and returns the tags of
+// that file. Extra arguments such as tag names are passed through.
+func (s *Session) Query(filePath string, args ...string) (map[string]any, error) {
+	if strings.ContainsAny(filePath, "\r\n") {
+		return nil, fmt.Errorf("file name contains a newline: %q", filePath)
+	}
+
+	tags, err := s.query(filePath, args)
+	if errors.Is(err, errExited) {
+		// Requests queued behind the file that crashed exiftool get one
+		// more chance on a fresh process
+		tags, err = s.query(filePath, args)
+	}
+	return tags, err
+}
+
+func (s *Session) query(filePath string, args []string) (map[string]any, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if s.proc == nil {
+		p, err := s.start()
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		s.proc = p
+	}
+	p := s.proc
+	s.nextID++
+	id := s.nextID
+	ch := make(chan response, 1)
+	p.pending[id] = ch
+	s.mu.Unlock()
+
+	var request strings.Builder
+	for _, arg := range append(args[:len(args):len(args)], "-json", "-charset", "filename=utf8", filePath) {
+		request.WriteString(arg + "\n")
+	}
+	request.WriteString(fmt.Sprintf("-execute%d\n", id))
+
+	s.writeMu.Lock()
+	_, err := io.WriteString(p.stdin, request.String())
+	s.writeMu.Unlock()
+	if err != nil {
+		s.mu.Lock()
+		delete(p.pending, id)
+		s.mu.Unlock()
+		p.cmd.Process.Kill()
+		return nil, fmt.Errorf("error writing to exiftool: %w", err)
+	}
+
+	var resp response
+	select {
+	case resp = <-ch:
+	case <-time.After(s.Timeout):
+		// A hung process would block every later request, restart it
+		log.Printf("exiftool timed out on %s, restarting", filePath)
+		p.cmd.Process.Kill()
+		return nil, fmt.Errorf("exiftool timed out on %s", filePath)
+	}
+	if resp.err != nil {
+		return nil, resp.err
+	}
+
+	// Files exiftool cannot read produce no JSON at all
+	if len(bytes.TrimSpace(resp.data)) == 0 {
+		return nil, fmt.Errorf("exiftool returned no data for %s", filePath)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(resp.data))
+	decoder.UseNumber()
+	var results []map[string]any
+	if err := decoder.Decode(&results); err != nil {
+		return nil, fmt.Errorf("error parsing exiftool output: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("exiftool returned no data for %s", filePath)
+	}
+	return results[0], nil
+}
+
+// Close asks exiftool to exit and waits for it, killing it if it does not
+// stop in time.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	p := s.proc
+	s.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+
+	s.writeMu.Lock()
+	io.WriteString(p.stdin, "-stay_open\nFalse\n")
+	p.stdin.Close()
+	s.writeMu.Unlock()
+
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+	return nil
+}
+
+// String converts a tag value to text. exiftool reports numeric-looking
+// values as JSON numbers and repeated tags as arrays.
+func String(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case []any:
+		return strings.Join(Strings(v), ", ")
+	}
+	return strings.TrimSpace(fmt.Sprint(value))
+}
+
+func Strings(value any) []string {
+	values, ok := value.([]any)
+	if !ok {
+		if s := String(value); s != "" {
+			return []string{s}
+		}
+		return nil
+	}
+	var result []string
+	for _, v := range values {
+		if s := String(v); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
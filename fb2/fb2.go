@@ -0,0 +1,207 @@
+package fb2
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+type Author struct {
+	FirstName  string `xml:"first-name" json:"firstName,omitempty"`
+	MiddleName string `xml:"middle-name" json:"middleName,omitempty"`
+	LastName   string `xml:"last-name" json:"lastName,omitempty"`
+	Nickname   string `xml:"nickname" json:"nickname,omitempty"`
+}
+
+// Name returns the author name in reading order, e.g. "Arkady Natanovich Strugatsky"
+func (a Author) Name() string {
+	name := join(a.FirstName, a.MiddleName, a.LastName)
+	if name == "" {
+		return clean(a.Nickname)
+	}
+	return name
+}
+
+// SortName returns the author name in "Last, First Middle" form
+func (a Author) SortName() string {
+	last := clean(a.LastName)
+	first := join(a.FirstName, a.MiddleName)
+	switch {
+	case last == "":
+		return a.Name()
+	case first == "":
+		return last
+	default:
+		return last + ", " + first
+	}
+}
+
+type Metadata struct {
+	Title          string   `json:"title"`
+	Authors        []Author `json:"authors,omitempty"`
+	Genres         []string `json:"genres,omitempty"`
+	Series         string   `json:"series,omitempty"`
+	SeriesNumber   int      `json:"seriesNumber,omitempty"`
+	Language       string   `json:"language,omitempty"`
+	SourceLanguage string   `json:"sourceLanguage,omitempty"`
+	Annotation     string   `json:"annotation,omitempty"`
+}
+
+type titleInfo struct {
+	Genres    []string `xml:"genre"`
+	Authors   []Author `xml:"author"`
+	BookTitle string   `xml:"book-title"`
+	Sequences []struct {
+		Name   string `xml:"name,attr"`
+		Number string `xml:"number,attr"`
+	} `xml:"sequence"`
+	Lang       string `xml:"lang"`
+	SrcLang    string `xml:"src-lang"`
+	Annotation struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"annotation"`
+}
+
+// IsFB2 reports whether the path looks like a FictionBook file, either plain
+// or zipped.
+func IsFB2(filePath string) bool {
+	lower := strings.ToLower(filePath)
+	return strings.HasSuffix(lower, ".fb2") || strings.HasSuffix(lower, ".fb2.zip")
+}
+
+func ReadMetadata(filePath string) (*Metadata, error) {
+	if strings.HasSuffix(strings.ToLower(filePath), ".zip") {
+		return readZip(filePath)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening fb2: %w", err)
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+func readZip(filePath string) (*Metadata, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening fb2 archive: %w", err)
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".fb2") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s in archive: %w", f.Name, err)
+		}
+		defer rc.Close()
+		return Parse(rc)
+	}
+
+	return nil, fmt.Errorf("no .fb2 file in archive %s", filePath)
+}
+
+// Parse reads the title-info section of a FictionBook document. Decoding
+// stops once title-info is read, so the body and embedded binaries are never
+// loaded.
+func Parse(r io.Reader) (*Metadata, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported encoding %q: %w", charset, err)
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no title-info in fb2 document")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing fb2: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "body", "binary":
+			return nil, fmt.Errorf("no title-info in fb2 document")
+		case "title-info":
+			var info titleInfo
+			if err := decoder.DecodeElement(&info, &start); err != nil {
+				return nil, fmt.Errorf("error parsing title-info: %w", err)
+			}
+			return info.metadata(), nil
+		}
+	}
+}
+
+func (info *titleInfo) metadata() *Metadata {
+	metadata := &Metadata{
+		Title:          clean(info.BookTitle),
+		Language:       clean(info.Lang),
+		SourceLanguage: clean(info.SrcLang),
+		Annotation:     stripTags(info.Annotation.Inner),
+	}
+
+	for _, author := range info.Authors {
+		author = Author{
+			FirstName:  clean(author.FirstName),
+			MiddleName: clean(author.MiddleName),
+			LastName:   clean(author.LastName),
+			Nickname:   clean(author.Nickname),
+		}
+		if author.Name() != "" {
+			metadata.Authors = append(metadata.Authors, author)
+		}
+	}
+
+	for _, genre := range info.Genres {
+		if genre = clean(genre); genre != "" {
+			metadata.Genres = append(metadata.Genres, genre)
+		}
+	}
+
+	// Nested sequences describe sub-series; the first one is the main series
+	for _, sequence := range info.Sequences {
+		if name := clean(sequence.Name); name != "" {
+			metadata.Series = name
+			metadata.SeriesNumber, _ = strconv.Atoi(strings.TrimSpace(sequence.Number))
+			break
+		}
+	}
+
+	return metadata
+}
+
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func join(parts ...string) string {
+	return clean(strings.Join(parts, " "))
+}
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+func stripTags(s string) string {
+	return clean(html.UnescapeString(tagPattern.ReplaceAllString(s, " ")))
+}
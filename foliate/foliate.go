@@ -35,8 +35,14 @@ func NewFoliate() (*Foliate, error) {
 type BookInfo struct {
 	Filename string `json:"filename"`
 	Page     int    `json:"page"`
-	Title    string `json:"title"`
-	Author   string `json:"author,omitempty"`
+	Pages    int    `json:"pages,omitempty"`
+	// Title is empty when foliate has no metadata for the book
+	Title  string `json:"title"`
+	Author string `json:"author,omitempty"`
+	// LastRead is when foliate last saved the reading position, in unix seconds
+	LastRead int64 `json:"lastRead,omitempty"`
+	// Location is the EPUB CFI of the reading position
+	Location string `json:"location,omitempty"`
 }
 
 type FoliateMetadata struct {
@@ -111,26 +117,33 @@ func (f *Foliate) GetAllKnownBooks() (map[string]BookInfo, error) {
 			continue
 		}
 
-		title := foliateBook.Metadata.Title
-		if title == "" {
-			title = filepath.Base(filePath)
-		}
-
 		author := ""
 		if len(foliateBook.Metadata.Author) > 0 {
 			author = foliateBook.Metadata.Author[0].Name
 		}
 
-		page := 0
+		// Progress is [current, total] in foliate's own locations
+		page, pages := 0, 0
 		if len(foliateBook.Progress) > 0 {
 			page = foliateBook.Progress[0]
 		}
+		if len(foliateBook.Progress) > 1 {
+			pages = foliateBook.Progress[1]
+		}
+
+		var lastRead int64
+		if info, err := os.Stat(bookMetadataPath); err == nil {
+			lastRead = info.ModTime().Unix()
+		}
 
 		book := BookInfo{
 			Filename: filePath,
 			Page:     page,
-			Title:    title,
+			Pages:    pages,
+			Title:    foliateBook.Metadata.Title,
 			Author:   author,
+			LastRead: lastRead,
+			Location: foliateBook.LastLocation,
 		}
 
 		books[filePath] = book
@@ -3,6 +3,9 @@
 import {library} from '../models';
 import {main} from '../models';
 import {context} from '../models';
+import {stats} from '../models';
+
+export function CancelScan():Promise<boolean>;
 
 export function ExecCommand(arg1:string):Promise<void>;
 
@@ -10,12 +13,22 @@ export function GetBooks(arg1:string):Promise<Array<library.Book>>;
 
 export function GetCommandList():Promise<Array<main.Command>>;
 
+export function GetOpeners(arg1:string):Promise<Array<string>>;
+
+export function GetReadingSessions(arg1:string):Promise<Array<library.ReadingSession>>;
+
+export function GetReadingStats(arg1:string,arg2:string):Promise<stats.Report>;
+
 export function Greet(arg1:string):Promise<string>;
 
 export function Hide():Promise<void>;
 
-export function OpenBook(arg1:string):Promise<void>;
+export function OpenBook(arg1:string):Promise<library.OpenResult>;
+
+export function OpenBookWith(arg1:string,arg2:string):Promise<library.OpenResult>;
+
+export function RecreateLibrary():Promise<library.ScanReport>;
 
-export function RecreateLibrary():Promise<void>;
+export function SearchBooksDetailed(arg1:string):Promise<Array<library.SearchResult>>;
 
 export function Shutdown(arg1:context.Context):Promise<void>;
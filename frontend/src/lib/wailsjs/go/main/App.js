@@ -2,6 +2,10 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function CancelScan() {
+  return window['go']['main']['App']['CancelScan']();
+}
+
 export function ExecCommand(arg1) {
   return window['go']['main']['App']['ExecCommand'](arg1);
 }
@@ -14,6 +18,18 @@ export function GetCommandList() {
   return window['go']['main']['App']['GetCommandList']();
 }
 
+export function GetOpeners(arg1) {
+  return window['go']['main']['App']['GetOpeners'](arg1);
+}
+
+export function GetReadingSessions(arg1) {
+  return window['go']['main']['App']['GetReadingSessions'](arg1);
+}
+
+export function GetReadingStats(arg1, arg2) {
+  return window['go']['main']['App']['GetReadingStats'](arg1, arg2);
+}
+
 export function Greet(arg1) {
   return window['go']['main']['App']['Greet'](arg1);
 }
@@ -26,10 +42,18 @@ export function OpenBook(arg1) {
   return window['go']['main']['App']['OpenBook'](arg1);
 }
 
+export function OpenBookWith(arg1, arg2) {
+  return window['go']['main']['App']['OpenBookWith'](arg1, arg2);
+}
+
 export function RecreateLibrary() {
   return window['go']['main']['App']['RecreateLibrary']();
 }
 
+export function SearchBooksDetailed(arg1) {
+  return window['go']['main']['App']['SearchBooksDetailed'](arg1);
+}
+
 export function Shutdown(arg1) {
   return window['go']['main']['App']['Shutdown'](arg1);
 }
@@ -4,8 +4,25 @@ export namespace library {
 	    filepath: string;
 	    title: string;
 	    author?: string;
+	    authorSort?: string;
 	    format: string;
 	    page?: number;
+	    pages?: number;
+	    language?: string;
+	    identifiers?: string;
+	    publisher?: string;
+	    subjects?: string;
+	    series?: string;
+	    seriesIndex?: number;
+	    description?: string;
+	    root?: string;
+	    lastRead?: number;
+	    percent?: number;
+	    finished?: boolean;
+	    highlights?: number;
+	    location?: string;
+	    progressSource?: string;
+	    frecency?: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new Book(source);
@@ -16,10 +33,147 @@ export namespace library {
 	        this.filepath = source["filepath"];
 	        this.title = source["title"];
 	        this.author = source["author"];
+	        this.authorSort = source["authorSort"];
 	        this.format = source["format"];
 	        this.page = source["page"];
+	        this.pages = source["pages"];
+	        this.language = source["language"];
+	        this.identifiers = source["identifiers"];
+	        this.publisher = source["publisher"];
+	        this.subjects = source["subjects"];
+	        this.series = source["series"];
+	        this.seriesIndex = source["seriesIndex"];
+	        this.description = source["description"];
+	        this.root = source["root"];
+	        this.lastRead = source["lastRead"];
+	        this.percent = source["percent"];
+	        this.finished = source["finished"];
+	        this.highlights = source["highlights"];
+	        this.location = source["location"];
+	        this.progressSource = source["progressSource"];
+	        this.frecency = source["frecency"];
 	    }
 	}
+	export class FieldMatch {
+	    field: string;
+	    ranges: number[][];
+	
+	    static createFrom(source: any = {}) {
+	        return new FieldMatch(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.field = source["field"];
+	        this.ranges = source["ranges"];
+	    }
+	}
+	export class OpenResult {
+	    opener: string;
+	    source?: string;
+	    page?: number;
+	    location?: string;
+	    resumes: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new OpenResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.opener = source["opener"];
+	        this.source = source["source"];
+	        this.page = source["page"];
+	        this.location = source["location"];
+	        this.resumes = source["resumes"];
+	    }
+	}
+	export class ReadingSession {
+	    id: number;
+	    filepath: string;
+	    opener?: string;
+	    pid: number;
+	    startedAt: number;
+	    endedAt?: number;
+	    startPage?: number;
+	    endPage?: number;
+	    startLocation?: string;
+	    endLocation?: string;
+	    endFinished?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new ReadingSession(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.filepath = source["filepath"];
+	        this.opener = source["opener"];
+	        this.pid = source["pid"];
+	        this.startedAt = source["startedAt"];
+	        this.endedAt = source["endedAt"];
+	        this.startPage = source["startPage"];
+	        this.endPage = source["endPage"];
+	        this.startLocation = source["startLocation"];
+	        this.endLocation = source["endLocation"];
+	        this.endFinished = source["endFinished"];
+	    }
+	}
+	export class ScanReport {
+	    added: number;
+	    updated: number;
+	    removed: number;
+	    unchanged: number;
+	    failed: number;
+	    duration: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ScanReport(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.added = source["added"];
+	        this.updated = source["updated"];
+	        this.removed = source["removed"];
+	        this.unchanged = source["unchanged"];
+	        this.failed = source["failed"];
+	        this.duration = source["duration"];
+	    }
+	}
+	export class SearchResult {
+	    book: Book;
+	    matches: FieldMatch[];
+	
+	    static createFrom(source: any = {}) {
+	        return new SearchResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.book = this.convertValues(source["book"], Book);
+	        this.matches = this.convertValues(source["matches"], FieldMatch);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 
 }
 
@@ -44,3 +198,142 @@ export namespace main {
 
 }
 
+export namespace stats {
+	
+	export class Author {
+	    author: string;
+	    seconds: number;
+	    pages: number;
+	    books: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Author(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.author = source["author"];
+	        this.seconds = source["seconds"];
+	        this.pages = source["pages"];
+	        this.books = source["books"];
+	    }
+	}
+	export class Day {
+	    date: string;
+	    seconds: number;
+	    pages: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Day(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.date = source["date"];
+	        this.seconds = source["seconds"];
+	        this.pages = source["pages"];
+	    }
+	}
+	export class Month {
+	    month: string;
+	    books: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Month(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.month = source["month"];
+	        this.books = source["books"];
+	    }
+	}
+	export class StreakInfo {
+	    current: number;
+	    longest: number;
+	    longestStart?: string;
+	    longestEnd?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new StreakInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.current = source["current"];
+	        this.longest = source["longest"];
+	        this.longestStart = source["longestStart"];
+	        this.longestEnd = source["longestEnd"];
+	    }
+	}
+	export class Speed {
+	    format: string;
+	    seconds: number;
+	    pages: number;
+	    pagesPerHour: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Speed(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.format = source["format"];
+	        this.seconds = source["seconds"];
+	        this.pages = source["pages"];
+	        this.pagesPerHour = source["pagesPerHour"];
+	    }
+	}
+	export class Report {
+	    from: string;
+	    to: string;
+	    timeZone: string;
+	    sessions: number;
+	    seconds: number;
+	    pages: number;
+	    days: Day[];
+	    finished: Month[];
+	    formats: Speed[];
+	    authors: Author[];
+	    streaks: StreakInfo;
+	
+	    static createFrom(source: any = {}) {
+	        return new Report(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.from = source["from"];
+	        this.to = source["to"];
+	        this.timeZone = source["timeZone"];
+	        this.sessions = source["sessions"];
+	        this.seconds = source["seconds"];
+	        this.pages = source["pages"];
+	        this.days = this.convertValues(source["days"], Day);
+	        this.finished = this.convertValues(source["finished"], Month);
+	        this.formats = this.convertValues(source["formats"], Speed);
+	        this.authors = this.convertValues(source["authors"], Author);
+	        this.streaks = this.convertValues(source["streaks"], StreakInfo);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
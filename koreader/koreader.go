@@ -0,0 +1,93 @@
+package koreader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Sidecar is what KOReader remembers about a book in its metadata file.
+type Sidecar struct {
+	Path    string
+	Title   string
+	Authors []string
+	// Page is the last page read; Pages the page count, 0 when unknown
+	Page  int
+	Pages int
+	// Percent is how far the book has been read, from 0 to 100
+	Percent float64
+	// Status is reading, complete or abandoned, empty for books KOReader
+	// has not been told about
+	Status     string
+	Highlights int
+	// LastRead is when KOReader last saved the file, in unix seconds
+	LastRead int64
+}
+
+// SidecarPath returns where KOReader keeps the metadata of the book at
+// bookPath: book.epub has book.sdr/metadata.epub.lua. With dir set, the
+// path is looked up below dir, like KOReader's docsettings folder does.
+func SidecarPath(bookPath, dir string) string {
+	ext := filepath.Ext(bookPath)
+	sdr := strings.TrimSuffix(bookPath, ext) + ".sdr"
+	if dir != "" {
+		sdr = filepath.Join(dir, sdr)
+	}
+	return filepath.Join(sdr, "metadata."+strings.TrimPrefix(ext, ".")+".lua")
+}
+
+// ReadSidecar parses a metadata.<ext>.lua file.
+func ReadSidecar(path string) (*Sidecar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := parseSettings(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	sidecar := &Sidecar{Path: path}
+	if info, err := os.Stat(path); err == nil {
+		sidecar.LastRead = info.ModTime().Unix()
+	}
+
+	props := settings.table("doc_props")
+	sidecar.Title = strings.TrimSpace(props.string("title"))
+	// Several authors are separated by newlines
+	for _, author := range strings.Split(props.string("authors"), "\n") {
+		if author = strings.TrimSpace(author); author != "" {
+			sidecar.Authors = append(sidecar.Authors, author)
+		}
+	}
+
+	sidecar.Page = int(settings.number("last_page"))
+	sidecar.Pages = int(settings.number("doc_pages"))
+	stats := settings.table("stats")
+	if sidecar.Pages == 0 {
+		sidecar.Pages = int(stats.number("pages"))
+	}
+	sidecar.Percent = min(100, max(0, settings.number("percent_finished")*100))
+	sidecar.Status = settings.table("summary").string("status")
+
+	// Newer versions keep a list of annotations, older ones highlights by
+	// page and a count in the statistics
+	switch {
+	case settings.table("annotations") != nil:
+		for _, annotation := range settings.table("annotations") {
+			if a, ok := annotation.(table); ok && a["pos0"] != nil {
+				sidecar.Highlights++
+			}
+		}
+	case settings.table("highlight") != nil:
+		for _, page := range settings.table("highlight") {
+			if highlights, ok := page.(table); ok {
+				sidecar.Highlights += len(highlights)
+			}
+		}
+	default:
+		sidecar.Highlights = int(stats.number("highlights"))
+	}
+	return sidecar, nil
+}
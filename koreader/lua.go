@@ -0,0 +1,400 @@
+package koreader
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// KOReader saves its settings as a Lua chunk that returns a table literal.
+// Only literals are parsed, nothing is executed. Lua values are represented
+// with plain Go values:
+//
+//	nil      nil
+//	boolean  bool
+//	number   float64
+//	string   string
+//	table    table, keyed by string, float64 or bool
+type table map[any]any
+
+func (t table) table(key string) table {
+	value, _ := t[key].(table)
+	return value
+}
+
+func (t table) string(key string) string {
+	value, _ := t[key].(string)
+	return value
+}
+
+func (t table) number(key string) float64 {
+	value, _ := t[key].(float64)
+	return value
+}
+
+// SyntaxError describes where a settings file stopped being a literal.
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Msg, e.Offset)
+}
+
+type parser struct {
+	data string
+	pos  int
+}
+
+// parseSettings parses `return { ... }`, optionally preceded by comments.
+func parseSettings(data string) (table, error) {
+	p := &parser{data: data}
+	p.skipSpace()
+	if p.keyword("return") {
+		p.skipSpace()
+	}
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := value.(table)
+	if !ok {
+		return nil, p.errorf("settings are not a table")
+	}
+	p.skipSpace()
+	if p.pos < len(p.data) {
+		return nil, p.errorf("unexpected %q after the table", p.data[p.pos])
+	}
+	return t, nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &SyntaxError{Offset: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.data) {
+		return p.data[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == '\v':
+			p.pos++
+		case strings.HasPrefix(p.data[p.pos:], "--"):
+			p.pos += 2
+			if level, ok := p.longBracket(); ok {
+				// Block comment, an unterminated one runs to the end
+				end := strings.Index(p.data[p.pos:], "]"+strings.Repeat("=", level)+"]")
+				if end < 0 {
+					p.pos = len(p.data)
+				} else {
+					p.pos += end + level + 2
+				}
+				continue
+			}
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// longBracket consumes the opening of a long string or comment, [[ or [=[
+// and so on, and returns its level.
+func (p *parser) longBracket() (int, bool) {
+	if p.peek() != '[' {
+		return 0, false
+	}
+	i := p.pos + 1
+	for i < len(p.data) && p.data[i] == '=' {
+		i++
+	}
+	if i >= len(p.data) || p.data[i] != '[' {
+		return 0, false
+	}
+	level := i - p.pos - 1
+	p.pos = i + 1
+	return level, true
+}
+
+// keyword consumes word if it is next and not the start of a longer name.
+func (p *parser) keyword(word string) bool {
+	if !strings.HasPrefix(p.data[p.pos:], word) {
+		return false
+	}
+	end := p.pos + len(word)
+	if end < len(p.data) && isNameChar(p.data[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (p *parser) value() (any, error) {
+	p.skipSpace()
+	switch c := p.peek(); {
+	case c == '{':
+		return p.table()
+	case c == '"' || c == '\'':
+		return p.quoted()
+	case c == '[':
+		level, ok := p.longBracket()
+		if !ok {
+			return nil, p.errorf("unexpected '['")
+		}
+		return p.longString(level)
+	case c == '-' || c == '.' || c >= '0' && c <= '9':
+		return p.number()
+	case p.keyword("true"):
+		return true, nil
+	case p.keyword("false"):
+		return false, nil
+	case p.keyword("nil"):
+		return nil, nil
+	case c == 0:
+		return nil, p.errorf("unexpected end of file")
+	}
+	return nil, p.errorf("unexpected %q", p.peek())
+}
+
+func (p *parser) table() (table, error) {
+	p.pos++ // {
+	t := make(table)
+	index := 1.0
+	for {
+		p.skipSpace()
+		if p.peek() == '}' {
+			p.pos++
+			return t, nil
+		}
+
+		var key any
+		switch {
+		case p.peek() == '[' && p.pos+1 < len(p.data) && p.data[p.pos+1] != '[' && p.data[p.pos+1] != '=':
+			// [key] = value
+			p.pos++
+			p.skipSpace()
+			start := p.pos
+			var err error
+			if key, err = p.value(); err != nil {
+				return nil, err
+			}
+			if !validKey(key) {
+				p.pos = start
+				return nil, p.errorf("invalid table key")
+			}
+			p.skipSpace()
+			if p.peek() != ']' {
+				return nil, p.errorf("expected ']'")
+			}
+			p.pos++
+			if err := p.expect('='); err != nil {
+				return nil, err
+			}
+		case isNameStart(p.peek()):
+			// name = value, unless the name is a value like true
+			start := p.pos
+			for p.pos < len(p.data) && isNameChar(p.data[p.pos]) {
+				p.pos++
+			}
+			name := p.data[start:p.pos]
+			p.skipSpace()
+			if p.peek() == '=' && !strings.HasPrefix(p.data[p.pos:], "==") {
+				p.pos++
+				key = name
+			} else {
+				p.pos = start
+			}
+		}
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			key = index
+			index++
+		}
+		if value != nil {
+			t[key] = value
+		}
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',', ';':
+			p.pos++
+		case '}':
+		default:
+			return nil, p.errorf("expected ',' or '}'")
+		}
+	}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (p *parser) expect(c byte) error {
+	p.skipSpace()
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+// validKey reports whether a table can be keyed by key. Tables are not
+// hashable here, and Lua itself does not allow nil or NaN keys.
+func validKey(key any) bool {
+	switch key := key.(type) {
+	case string, bool:
+		return true
+	case float64:
+		return !math.IsNaN(key)
+	}
+	return false
+}
+
+func (p *parser) number() (float64, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		// Exponents may have a sign
+		if isNameChar(c) || c == '.' || (c == '-' || c == '+') && strings.ContainsRune("eEpP", rune(p.data[p.pos-1])) {
+			p.pos++
+			continue
+		}
+		break
+	}
+	text := p.data[start:p.pos]
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		// Hexadecimal integers are not valid floats for Go without an exponent
+		n, intErr := strconv.ParseInt(text, 0, 64)
+		if intErr != nil {
+			p.pos = start
+			return 0, p.errorf("invalid number %q", text)
+		}
+		value = float64(n)
+	}
+	return value, nil
+}
+
+func (p *parser) longString(level int) (string, error) {
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(p.data[p.pos:], closing)
+	if end < 0 {
+		return "", p.errorf("unterminated long string")
+	}
+	s := p.data[p.pos : p.pos+end]
+	p.pos += end + len(closing)
+	// A newline right after the opening bracket is skipped
+	if strings.HasPrefix(s, "\r\n") {
+		s = s[2:]
+	} else if strings.HasPrefix(s, "\n") {
+		s = s[1:]
+	}
+	return s, nil
+}
+
+func (p *parser) quoted() (string, error) {
+	quote := p.data[p.pos]
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.data) {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case quote:
+			return b.String(), nil
+		case '\n':
+			return "", p.errorf("newline in string")
+		case '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+var simpleEscapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+	'\\': '\\', '"': '"', '\'': '\'', '\n': '\n',
+}
+
+func (p *parser) escape(b *strings.Builder) error {
+	c := p.peek()
+	if c == 0 {
+		return p.errorf("unterminated string")
+	}
+	if out, ok := simpleEscapes[c]; ok {
+		p.pos++
+		b.WriteByte(out)
+		return nil
+	}
+	switch {
+	case c == 'z':
+		// \z skips the following whitespace
+		p.pos++
+		for p.pos < len(p.data) && strings.IndexByte(" \t\r\n\f\v", p.data[p.pos]) >= 0 {
+			p.pos++
+		}
+	case c == 'x':
+		if p.pos+3 > len(p.data) {
+			return p.errorf("invalid escape")
+		}
+		n, err := strconv.ParseUint(p.data[p.pos+1:p.pos+3], 16, 8)
+		if err != nil {
+			return p.errorf("invalid escape")
+		}
+		b.WriteByte(byte(n))
+		p.pos += 3
+	case c >= '0' && c <= '9':
+		// Up to three decimal digits
+		end := p.pos
+		for end < len(p.data) && end < p.pos+3 && p.data[end] >= '0' && p.data[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(p.data[p.pos:end])
+		if err != nil || n > 255 {
+			return p.errorf("invalid escape")
+		}
+		b.WriteByte(byte(n))
+		p.pos = end
+	case c == 'u':
+		end := strings.IndexByte(p.data[p.pos:], '}')
+		if p.pos+1 >= len(p.data) || p.data[p.pos+1] != '{' || end < 0 {
+			return p.errorf("invalid escape")
+		}
+		n, err := strconv.ParseUint(p.data[p.pos+2:p.pos+end], 16, 32)
+		if err != nil || n > utf8.MaxRune {
+			return p.errorf("invalid escape")
+		}
+		b.WriteRune(rune(n))
+		p.pos += end + 1
+	default:
+		return p.errorf("invalid escape '\\%c'", c)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package koreader
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want table
+	}{
+		{
+			name: "metadata file",
+			data: `-- we can read Lua syntax here!
+return {
+    ["doc_props"] = {
+        ["authors"] = "Аркадий Стругацкий\nБорис Стругацкий",
+        ["title"] = "Пикник на обочине",
+    },
+    ["doc_pages"] = 212,
+    ["percent_finished"] = 0.5,
+    ["summary"] = {
+        ["status"] = "reading",
+    },
+}
+`,
+			want: table{
+				"doc_props": table{
+					"authors": "Аркадий Стругацкий\nБорис Стругацкий",
+					"title":   "Пикник на обочине",
+				},
+				"doc_pages":        212.0,
+				"percent_finished": 0.5,
+				"summary":          table{"status": "reading"},
+			},
+		},
+		{
+			name: "names and list items",
+			data: `return { a = 1, true, b = false; "two", nil, [3] = 'x' }`,
+			want: table{"a": 1.0, 1.0: true, "b": false, 2.0: "two", 3.0: "x"},
+		},
+		{
+			name: "keys",
+			data: `return { ["s"] = 1, [ 2 ] = 2, [true] = 3, [-0.5] = 4 }`,
+			want: table{"s": 1.0, 2.0: 2.0, true: 3.0, -0.5: 4.0},
+		},
+		{
+			name: "numbers",
+			data: `{ -1, 1e3, 2.5E-1, 0x10, .5 }`,
+			want: table{1.0: -1.0, 2.0: 1000.0, 3.0: 0.25, 4.0: 16.0, 5.0: 0.5},
+		},
+		{
+			name: "escapes",
+			data: `{ "tab\there", "quote\"s", '\65\x42', "a\z
+                  b", "caf\u{E9}" }`,
+			want: table{1.0: "tab\there", 2.0: `quote"s`, 3.0: "AB", 4.0: "ab", 5.0: "café"},
+		},
+		{
+			name: "long strings and comments",
+			data: `--[[ a block
+comment ]] return { [[
+first line
+second]], --[==[ another ]==] [==[with ]] inside]==] }`,
+			want: table{1.0: "first line\nsecond", 2.0: "with ]] inside"},
+		},
+		{
+			name: "empty",
+			data: `return {}`,
+			want: table{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSettings(tt.data)
+			if err != nil {
+				t.Fatalf("parseSettings: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSettings = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSettingsErrors(t *testing.T) {
+	for _, data := range []string{
+		``,
+		`return 1`,
+		`return { 1, 2`,
+		`return { "unterminated }`,
+		`return { a = os.time() }`,
+		`return { [[never closed }`,
+		`return {} {}`,
+		// Keys Lua does not allow or that cannot be hashed
+		`return { [{}] = 1 }`,
+		`return { [{ a = 1 }] = 1 }`,
+		`return { [nil] = 1 }`,
+		`return { [-nan] = 1 }`,
+	} {
+		_, err := parseSettings(data)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("parseSettings(%q) = %v, want a *SyntaxError", data, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package library
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultFrecencyHalfLife is used when Library.FrecencyHalfLife is zero.
+const DefaultFrecencyHalfLife = 14 * 24 * time.Hour
+
+// frecencyWeight is how much a book's frecency can lift it over books that
+// match a search better.
+const frecencyWeight = 1.0
+
+// RecordOpen remembers that a book was opened now.
+func (l *Library) RecordOpen(filePath string) error {
+	_, err := l.DB.Exec(`INSERT INTO open_events (filepath, opened_at) VALUES (?, ?)`, filePath, time.Now().Unix())
+	l.invalidateIndex()
+	return err
+}
+
+func (l *Library) frecencyHalfLife() time.Duration {
+	if l.FrecencyHalfLife > 0 {
+		return l.FrecencyHalfLife
+	}
+	return DefaultFrecencyHalfLife
+}
+
+// openEvents returns when every opened book was opened, in unix seconds.
+func (l *Library) openEvents() (map[string][]int64, error) {
+	rows, err := l.DB.Query(`SELECT filepath, opened_at FROM open_events`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	opens := make(map[string][]int64)
+	for rows.Next() {
+		var path string
+		var openedAt int64
+		if err := rows.Scan(&path, &openedAt); err != nil {
+			return nil, err
+		}
+		opens[path] = append(opens[path], openedAt)
+	}
+	return opens, rows.Err()
+}
+
+// frecency scores the opens of a book: each open counts 1 when it just
+// happened and half as much every half-life after that.
+func (l *Library) frecency(opens []int64, now time.Time) float64 {
+	halfLife := l.frecencyHalfLife().Seconds()
+	score := 0.0
+	for _, openedAt := range opens {
+		age := max(0, now.Sub(time.Unix(openedAt, 0)).Seconds())
+		score += math.Exp2(-age / halfLife)
+	}
+	return score
+}
+
+// sortByFrecency blends frecency into the relevance order of books, which
+// must be best first: the n-th book starts with a score of 1/n that grows
+// with the log of its frecency.
+func sortByFrecency(books []Book) {
+	scores := make(map[string]float64, len(books))
+	for i, book := range books {
+		scores[book.FilePath] = 1 / float64(i+1) * (1 + frecencyWeight*math.Log1p(book.Frecency))
+	}
+	sort.SliceStable(books, func(i, j int) bool {
+		return scores[books[i].FilePath] > scores[books[j].FilePath]
+	})
+}
+
+// sortContinueReading puts the books opened most often and most recently
+// first, then books a reader opened on its own, most recent first. The
+// rest keep their order.
+func sortContinueReading(books []Book) {
+	sort.SliceStable(books, func(i, j int) bool {
+		a, b := books[i], books[j]
+		if a.Frecency != b.Frecency {
+			return a.Frecency > b.Frecency
+		}
+		return a.LastRead > b.LastRead
+	})
+}
@@ -0,0 +1,217 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The full-text index is not part of the versioned migrations because
+// whether SQLite has FTS5 depends on the build (go-sqlite3 needs the
+// sqlite_fts5 tag). Without FTS5 the triggers are dropped so books can
+// still be written, and the index is rebuilt once a build with FTS5 opens
+// the database again. search_text holds Latin transliterations of Cyrillic
+// titles and authors.
+
+const ftsTable = `
+	CREATE VIRTUAL TABLE books_fts USING fts5(
+		title, author, series, subjects, description, search_text,
+		content='books', content_rowid='id',
+		tokenize='unicode61 remove_diacritics 2', prefix='2 3'
+	)`
+
+// ftsColumns must match ftsTable; initFTS rebuilds the index when the
+// existing table lacks one of them.
+var ftsColumns = []string{"title", "author", "series", "subjects", "description", "search_text"}
+
+var ftsTriggers = map[string]string{
+	"books_fts_insert": `CREATE TRIGGER books_fts_insert AFTER INSERT ON books BEGIN
+		INSERT INTO books_fts(rowid, title, author, series, subjects, description, search_text)
+		VALUES (new.id, new.title, new.author, new.series, new.subjects, new.description, new.search_text);
+	END`,
+	"books_fts_delete": `CREATE TRIGGER books_fts_delete AFTER DELETE ON books BEGIN
+		INSERT INTO books_fts(books_fts, rowid, title, author, series, subjects, description, search_text)
+		VALUES ('delete', old.id, old.title, old.author, old.series, old.subjects, old.description, old.search_text);
+	END`,
+	"books_fts_update": `CREATE TRIGGER books_fts_update AFTER UPDATE OF title, author, series, subjects, description, search_text ON books BEGIN
+		INSERT INTO books_fts(books_fts, rowid, title, author, series, subjects, description, search_text)
+		VALUES ('delete', old.id, old.title, old.author, old.series, old.subjects, old.description, old.search_text);
+		INSERT INTO books_fts(rowid, title, author, series, subjects, description, search_text)
+		VALUES (new.id, new.title, new.author, new.series, new.subjects, new.description, new.search_text);
+	END`,
+}
+
+// ftsWeights are the bm25 weights of the indexed columns, in order. The
+// transliterations count like authors.
+const ftsWeights = "10.0, 5.0, 3.0, 1.0, 1.0, 5.0"
+
+func ftsAvailable(db *sql.DB) bool {
+	var used bool
+	err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&used)
+	return err == nil && used
+}
+
+// dropFTSTriggers lets a build without FTS5 write books. The index is
+// rebuilt when a build with FTS5 opens the database again.
+func (l *Library) dropFTSTriggers() error {
+	for name := range ftsTriggers {
+		if _, err := l.DB.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ftsCurrent reports whether the index and its triggers are up to date.
+func (l *Library) ftsCurrent() (bool, error) {
+	var triggers int
+	err := l.DB.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'books_fts_%'`).Scan(&triggers)
+	if err != nil || triggers != len(ftsTriggers) {
+		return false, err
+	}
+	var columns int
+	err = l.DB.QueryRow(`SELECT count(*) FROM pragma_table_info('books_fts')`).Scan(&columns)
+	return err == nil && columns == len(ftsColumns), err
+}
+
+// initFTS makes sure the full-text index exists and is in sync with the
+// books table.
+func (l *Library) initFTS() error {
+	current, err := l.ftsCurrent()
+	if err != nil || current {
+		return err
+	}
+
+	// The index is missing, has other columns, or books were written
+	// without the triggers
+	tx, err := l.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for name := range ftsTriggers {
+		if _, err := tx.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DROP TABLE IF EXISTS books_fts`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ftsTable); err != nil {
+		return fmt.Errorf("error creating full-text index: %w", err)
+	}
+	for _, trigger := range ftsTriggers {
+		if _, err := tx.Exec(trigger); err != nil {
+			return fmt.Errorf("error creating full-text trigger: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO books_fts(books_fts) VALUES ('rebuild')`); err != nil {
+		return fmt.Errorf("error rebuilding full-text index: %w", err)
+	}
+	log.Printf("Rebuilt full-text index")
+	return tx.Commit()
+}
+
+// searchFTS returns the paths of books matching an FTS5 query, best first.
+func (l *Library) searchFTS(query string) ([]string, error) {
+	if query == "" {
+		return nil, nil
+	}
+	rows, err := l.DB.Query(`
+		SELECT books.filepath
+		FROM books_fts JOIN books ON books.id = books_fts.rowid
+		WHERE books_fts MATCH ?
+		ORDER BY bm25(books_fts, `+ftsWeights+`)`, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// typoMatch reports whether every word of term is within a small edit
+// distance of one of candidates, the formWords of a text. Short words must
+// match exactly, which the other searches already cover.
+func typoMatch(term string, candidates []string) bool {
+	words := strings.FieldsFunc(foldText(term), isSeparator)
+	if len(words) == 0 {
+		return false
+	}
+	for _, word := range words {
+		allowed, ok := maxTypos(word)
+		if !ok {
+			return false
+		}
+		length := utf8.RuneCountInString(word)
+		matched := false
+		for _, candidate := range candidates {
+			// Words that differ more in length are too far apart anyway
+			if diff := utf8.RuneCountInString(candidate) - length; diff > allowed || -diff > allowed {
+				continue
+			}
+			if editDistance(word, candidate) <= allowed {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// maxTypos is the edit distance allowed for a word of the query, which is
+// too short to have typos when ok is false.
+func maxTypos(word string) (allowed int, ok bool) {
+	switch n := len([]rune(word)); {
+	case n < 4:
+		return 0, false
+	case n < 8:
+		return 1, true
+	}
+	return 2, true
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// editDistance is the Levenshtein distance that also counts swapping two
+// adjacent letters as a single edit.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(t)]
+}
@@ -0,0 +1,271 @@
+package library
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldMatch is where a search matched a book. Field is title, author,
+// series, subjects or description, and Ranges are the [start, end) rune
+// offsets of the matched characters in that field of the book.
+type FieldMatch struct {
+	Field  string   `json:"field"`
+	Ranges [][2]int `json:"ranges"`
+}
+
+// SearchResult is a book found by SearchBooksDetailed and the fields that
+// matched, in the order above. Matches is empty when the query has neither
+// words nor title, author, series or tag filters.
+type SearchResult struct {
+	Book    Book         `json:"book"`
+	Matches []FieldMatch `json:"matches"`
+}
+
+// SearchBooksDetailed is SearchBooks with the matched characters of every
+// book, for highlighting.
+func (l *Library) SearchBooksDetailed(query string) ([]SearchResult, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	books, err := l.search(q)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, len(books))
+	for i, book := range books {
+		results[i] = SearchResult{Book: book, Matches: q.highlight(book)}
+	}
+	return results, nil
+}
+
+// highlight finds what made book match q, the same way the search did:
+// words at the start of words in any field and title or author words a
+// typo away, or else a fuzzy match of the title and author.
+func (q Query) highlight(book Book) []FieldMatch {
+	fields := []struct{ name, value string }{
+		{"title", book.Title},
+		{"author", book.Author},
+		{"series", book.Series},
+		{"subjects", book.Subjects},
+		{"description", book.Description},
+	}
+	ranges := make([][][2]int, len(fields))
+
+	if term := strings.TrimSpace(q.SearchText()); term != "" {
+		found := false
+		for i, field := range fields {
+			ranges[i] = wordRanges(field.value, term)
+			found = found || len(ranges[i]) > 0
+		}
+		// The fuzzy and typo searches look at the title and author joined
+		// by a space
+		joined := book.Title + " " + book.Author
+		matched := typoRanges(joined, term)
+		if !found && len(matched) == 0 {
+			matched = fuzzyRanges(joined, term)
+		}
+		title, author := splitRanges(matched, utf8.RuneCountInString(book.Title))
+		ranges[0] = append(ranges[0], title...)
+		ranges[1] = append(ranges[1], author...)
+	}
+
+	for _, filter := range q.Filters {
+		if filter.Negate {
+			continue
+		}
+		switch filter.Field {
+		case "title":
+			ranges[0] = append(ranges[0], substringRanges(book.Title, filter.Value)...)
+		case "author":
+			ranges[1] = append(ranges[1], substringRanges(book.Author, filter.Value)...)
+		case "series":
+			ranges[2] = append(ranges[2], substringRanges(book.Series, filter.Value)...)
+		case "tag":
+			ranges[3] = append(ranges[3], substringRanges(book.Subjects, filter.Value)...)
+		}
+	}
+
+	var matches []FieldMatch
+	for i, field := range fields {
+		if merged := mergeRanges(ranges[i]); len(merged) > 0 {
+			matches = append(matches, FieldMatch{Field: field.name, Ranges: merged})
+		}
+	}
+	return matches
+}
+
+// formRanges runs find on every normalised form of text and maps the rune
+// ranges it returns back to text.
+func formRanges(text string, find func(form []rune) [][2]int) [][2]int {
+	var ranges [][2]int
+	for _, form := range foldedForms(text) {
+		for _, r := range find([]rune(form.text)) {
+			from, to := form.originalRange(r[0], r[1])
+			ranges = append(ranges, [2]int{from, to})
+		}
+	}
+	return mergeRanges(ranges)
+}
+
+// queryWords are the words of query in all its normalised forms.
+func queryWords(query string) [][]rune {
+	var words [][]rune
+	for _, form := range foldedForms(query) {
+		for _, word := range strings.FieldsFunc(form.text, isSeparator) {
+			words = append(words, []rune(word))
+		}
+	}
+	return words
+}
+
+// wordRanges returns the ranges of text where a word starts with a word of
+// query, like the prefix search of the full-text index.
+func wordRanges(text, query string) [][2]int {
+	words := queryWords(query)
+	return formRanges(text, func(form []rune) [][2]int {
+		var ranges [][2]int
+		for _, span := range wordSpans(form) {
+			for _, word := range words {
+				if hasRunePrefix(form[span[0]:span[1]], word) {
+					ranges = append(ranges, [2]int{span[0], span[0] + len(word)})
+				}
+			}
+		}
+		return ranges
+	})
+}
+
+// substringRanges returns the ranges of text that contain value, like the
+// field filters.
+func substringRanges(text, value string) [][2]int {
+	var needles [][]rune
+	for _, form := range foldedForms(strings.TrimSpace(value)) {
+		if form.text != "" {
+			needles = append(needles, []rune(form.text))
+		}
+	}
+	return formRanges(text, func(form []rune) [][2]int {
+		var ranges [][2]int
+		for _, needle := range needles {
+			for start := 0; start+len(needle) <= len(form); start++ {
+				if hasRunePrefix(form[start:], needle) {
+					ranges = append(ranges, [2]int{start, start + len(needle)})
+				}
+			}
+		}
+		return ranges
+	})
+}
+
+// fuzzyRanges returns the characters of text that the fuzzy search matched
+// to query: the first occurrence of every character of a form of query, in
+// order, in the first form of text that has them all.
+func fuzzyRanges(text, query string) [][2]int {
+	forms := foldedForms(text)
+	for _, q := range foldedForms(query) {
+		needle := []rune(q.text)
+		for _, form := range forms {
+			runes := []rune(form.text)
+			var ranges [][2]int
+			i := 0
+			for j := 0; j < len(runes) && i < len(needle); j++ {
+				if runes[j] != needle[i] {
+					continue
+				}
+				from, to := form.originalRange(j, j+1)
+				ranges = append(ranges, [2]int{from, to})
+				i++
+			}
+			if i == len(needle) {
+				return mergeRanges(ranges)
+			}
+		}
+	}
+	return nil
+}
+
+// typoRanges returns the words of text that are a typo away from a word of
+// query, as typoMatch allows.
+func typoRanges(text, query string) [][2]int {
+	words := strings.FieldsFunc(foldText(query), isSeparator)
+	return formRanges(text, func(form []rune) [][2]int {
+		var ranges [][2]int
+		for _, span := range wordSpans(form) {
+			candidate := string(form[span[0]:span[1]])
+			for _, word := range words {
+				if allowed, ok := maxTypos(word); ok && editDistance(word, candidate) <= allowed {
+					ranges = append(ranges, span)
+					break
+				}
+			}
+		}
+		return ranges
+	})
+}
+
+// wordSpans returns the [start, end) ranges of the words of runes.
+func wordSpans(runes []rune) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range runes {
+		switch {
+		case isSeparator(r) && start >= 0:
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		case !isSeparator(r) && start < 0:
+			start = i
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(runes)})
+	}
+	return spans
+}
+
+func hasRunePrefix(s, prefix []rune) bool {
+	if len(prefix) == 0 || len(prefix) > len(s) {
+		return false
+	}
+	for i, r := range prefix {
+		if s[i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// splitRanges splits ranges of "title author" into the ranges of the title,
+// which has titleLen runes, and of the author.
+func splitRanges(ranges [][2]int, titleLen int) (title, author [][2]int) {
+	authorStart := titleLen + 1
+	for _, r := range ranges {
+		if r[0] < titleLen {
+			title = append(title, [2]int{r[0], min(r[1], titleLen)})
+		}
+		if r[1] > authorStart {
+			author = append(author, [2]int{max(r[0], authorStart) - authorStart, r[1] - authorStart})
+		}
+	}
+	return title, author
+}
+
+// mergeRanges sorts ranges and joins the ones that overlap or touch.
+func mergeRanges(ranges [][2]int) [][2]int {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := [][2]int{ranges[0]}
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			last[1] = max(last[1], r[1])
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
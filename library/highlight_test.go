@@ -0,0 +1,120 @@
+package library
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMergeRanges(t *testing.T) {
+	tests := []struct {
+		ranges [][2]int
+		want   [][2]int
+	}{
+		{nil, nil},
+		{[][2]int{{2, 4}}, [][2]int{{2, 4}}},
+		{[][2]int{{5, 7}, {0, 2}}, [][2]int{{0, 2}, {5, 7}}},
+		{[][2]int{{0, 3}, {2, 5}}, [][2]int{{0, 5}}},
+		{[][2]int{{0, 2}, {2, 4}}, [][2]int{{0, 4}}},
+		{[][2]int{{0, 10}, {2, 4}, {12, 13}}, [][2]int{{0, 10}, {12, 13}}},
+	}
+	for _, tt := range tests {
+		if got := mergeRanges(tt.ranges); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeRanges(%v) = %v, want %v", tt.ranges, got, tt.want)
+		}
+	}
+}
+
+func TestSplitRanges(t *testing.T) {
+	// "Solaris Lem": the title has 7 runes, the author starts at 8
+	tests := []struct {
+		ranges        [][2]int
+		title, author [][2]int
+	}{
+		{[][2]int{{0, 3}}, [][2]int{{0, 3}}, nil},
+		{[][2]int{{8, 11}}, nil, [][2]int{{0, 3}}},
+		{[][2]int{{5, 10}}, [][2]int{{5, 7}}, [][2]int{{0, 2}}},
+		// The joining space belongs to neither
+		{[][2]int{{7, 8}}, nil, nil},
+		{[][2]int{{0, 1}, {9, 10}}, [][2]int{{0, 1}}, [][2]int{{1, 2}}},
+	}
+	for _, tt := range tests {
+		title, author := splitRanges(tt.ranges, 7)
+		if !reflect.DeepEqual(title, tt.title) || !reflect.DeepEqual(author, tt.author) {
+			t.Errorf("splitRanges(%v) = %v, %v, want %v, %v", tt.ranges, title, author, tt.title, tt.author)
+		}
+	}
+}
+
+func TestFormRanges(t *testing.T) {
+	tests := []struct {
+		name  string
+		find  func(text, query string) [][2]int
+		text  string
+		query string
+		want  [][2]int
+	}{
+		{"latin", wordRanges, "Roadside Picnic", "pic", [][2]int{{9, 12}}},
+		{"cyrillic by latin", wordRanges, "Пикник на обочине", "piknik oboch", [][2]int{{0, 6}, {10, 14}}},
+		{"latin by cyrillic", wordRanges, "Master i Margarita", "мастер", [][2]int{{0, 6}}},
+		// ий is transliterated as a single y
+		{"two runes to one", wordRanges, "Фёдор Достоевский", "dostoevsky", [][2]int{{6, 17}}},
+		{"one rune to two", wordRanges, "Чехов", "chekhov", [][2]int{{0, 5}}},
+		{"bgn spelling", wordRanges, "Ёлки", "yolki", [][2]int{{0, 4}}},
+		{"precomposed diacritics", wordRanges, "Garc\u00eda M\u00e1rquez", "garcia marq", [][2]int{{0, 6}, {7, 11}}},
+		// A combining mark belongs to the letter before it
+		{"combining marks", wordRanges, "Cafe\u0301 Society", "cafe", [][2]int{{0, 5}}},
+		{"combining marks inside", wordRanges, "Ma\u0301rquez", "marq", [][2]int{{0, 5}}},
+		{"special letters", substringRanges, "Łódź Straße", "lodz", [][2]int{{0, 4}}},
+		{"letter to two", substringRanges, "Łódź Straße", "strasse", [][2]int{{5, 11}}},
+		{"substring", substringRanges, "Война и мир", "ойн", [][2]int{{1, 4}}},
+		{"substring by latin", substringRanges, "Война и мир", "voyna", [][2]int{{0, 5}}},
+		{"fuzzy", fuzzyRanges, "Bulgakov", "blg", [][2]int{{0, 1}, {2, 4}}},
+		{"fuzzy by cyrillic", fuzzyRanges, "Master and Margarita", "Мастер", [][2]int{{0, 6}}},
+		{"fuzzy combining marks", fuzzyRanges, "Cafe\u0301", "ce", [][2]int{{0, 1}, {3, 5}}},
+		{"typo", typoRanges, "Solaris Lem", "solaros", [][2]int{{0, 7}}},
+		{"typo in cyrillic", typoRanges, "Достоевский", "dostoyevsky", [][2]int{{0, 11}}},
+		{"no match", wordRanges, "Solaris", "picnic", nil},
+	}
+	for _, tt := range tests {
+		if got := tt.find(tt.text, tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ranges of %q in %q = %v, want %v", tt.name, tt.query, tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestSearchBooksDetailedMatches checks that every book a search finds
+// comes with the ranges that made it match.
+func TestSearchBooksDetailedMatches(t *testing.T) {
+	l, root := newTestLibrary(t)
+	for _, name := range []string{
+		"Михаил Булгаков - Мастер и Маргарита.fb2",
+		"Mikhail Bulgakov - The Master and Margarita.epub",
+		"Фёдор Достоевский - Преступление и наказание.epub",
+		"Gabriel García Márquez - Cien años de soledad.epub",
+		"Stanisław Lem - Solaris.pdf",
+	} {
+		writeBook(t, root, name)
+	}
+	if _, err := l.Scan(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{
+		"мастер", "master", "bulgakov", "булгаков", "dostoevsky", "garcia", "marquez",
+		"soledad", "blgkv", "solaros", "stanislaw", "Мастер", "author:lem solaris",
+	} {
+		results, err := l.SearchBooksDetailed(query)
+		if err != nil {
+			t.Fatalf("SearchBooksDetailed(%q): %v", query, err)
+		}
+		if len(results) == 0 {
+			t.Errorf("SearchBooksDetailed(%q) found nothing", query)
+		}
+		for _, result := range results {
+			if len(result.Matches) == 0 {
+				t.Errorf("SearchBooksDetailed(%q): %q has no matches", query, result.Book.Title)
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package library
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ignoreFileName is read in every directory of a scan root and follows
+// gitignore syntax.
+const ignoreFileName = ".ignore"
+
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRuleSet is the patterns of one .ignore file, relative to the
+// directory that contains it.
+type ignoreRuleSet struct {
+	base     string
+	patterns []ignorePattern
+}
+
+// ignoreMatcher answers whether paths under a scan root are ignored. It
+// combines patterns from the configuration, which apply from the root, with
+// the .ignore files of the root and of every directory below it. Like git,
+// the last matching pattern wins, deeper files override shallower ones, and
+// nothing inside an ignored directory can be re-included.
+type ignoreMatcher struct {
+	root   string
+	global *ignoreRuleSet
+
+	mu    sync.Mutex
+	files map[string]*ignoreRuleSet
+}
+
+func newIgnoreMatcher(root string, patterns []string) *ignoreMatcher {
+	return &ignoreMatcher{
+		root:   root,
+		global: parseIgnorePatterns(root, patterns),
+		files:  make(map[string]*ignoreRuleSet),
+	}
+}
+
+// invalidate forgets the cached .ignore file of dir after it changed.
+func (m *ignoreMatcher) invalidate(dir string) {
+	m.mu.Lock()
+	delete(m.files, dir)
+	m.mu.Unlock()
+}
+
+func (m *ignoreMatcher) ruleSet(dir string) *ignoreRuleSet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rules, ok := m.files[dir]; ok {
+		return rules
+	}
+	rules, err := loadIgnoreFile(dir)
+	if err != nil {
+		log.Printf("Error reading %s: %v", filepath.Join(dir, ignoreFileName), err)
+	}
+	m.files[dir] = rules
+	return rules
+}
+
+func loadIgnoreFile(dir string) (*ignoreRuleSet, error) {
+	file, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parseIgnorePatterns(dir, lines), nil
+}
+
+func parseIgnorePatterns(base string, lines []string) *ignoreRuleSet {
+	rules := &ignoreRuleSet{base: base}
+	for _, line := range lines {
+		if pattern, ok := compileIgnorePattern(line); ok {
+			rules.patterns = append(rules.patterns, pattern)
+		}
+	}
+	if len(rules.patterns) == 0 {
+		return nil
+	}
+	return rules
+}
+
+// Match reports whether path is ignored, either itself or because one of
+// the directories containing it is.
+func (m *ignoreMatcher) Match(path string, isDir bool) bool {
+	rel, err := filepath.Rel(m.root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	dir := m.root
+	for i := range parts {
+		current := filepath.Join(dir, parts[i])
+		last := i == len(parts)-1
+		if m.matchOne(current, !last || isDir) {
+			return true
+		}
+		dir = current
+	}
+	return false
+}
+
+// matchOne applies every rule set that covers path, shallowest first.
+func (m *ignoreMatcher) matchOne(path string, isDir bool) bool {
+	ignored := false
+	if m.global != nil {
+		if matched, negate := m.global.match(path, isDir); matched {
+			ignored = !negate
+		}
+	}
+
+	rel, _ := filepath.Rel(m.root, filepath.Dir(path))
+	dir := m.root
+	dirs := []string{dir}
+	if rel != "." {
+		for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+			dir = filepath.Join(dir, part)
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, dir := range dirs {
+		rules := m.ruleSet(dir)
+		if rules == nil {
+			continue
+		}
+		if matched, negate := rules.match(path, isDir); matched {
+			ignored = !negate
+		}
+	}
+	return ignored
+}
+
+// match returns whether any pattern matched path and whether the last
+// matching pattern was a negation.
+func (r *ignoreRuleSet) match(path string, isDir bool) (matched bool, negate bool) {
+	rel, err := filepath.Rel(r.base, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false, false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range r.patterns {
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+		if pattern.re.MatchString(rel) {
+			matched = true
+			negate = pattern.negate
+		}
+	}
+	return matched, negate
+}
+
+// compileIgnorePattern turns one gitignore line into a regular expression
+// over slash-separated paths relative to the directory of the .ignore file.
+func compileIgnorePattern(line string) (ignorePattern, bool) {
+	var pattern ignorePattern
+
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern, false
+	}
+
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return pattern, false
+	}
+
+	// A slash anywhere but at the end anchors the pattern to its directory
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "**/") && (i == 0 || line[i-1] == '/'):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case line[i:] == "**" && (i == 0 || line[i-1] == '/'):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			r, size := utf8.DecodeRuneInString(line[i+1:])
+			re.WriteString(regexp.QuoteMeta(string(r)))
+			i += size
+		default:
+			// Copy whole runes, a lone byte of one is not valid UTF-8
+			r, size := utf8.DecodeRuneInString(line[i:])
+			re.WriteString(regexp.QuoteMeta(string(r)))
+			i += size - 1
+		}
+	}
+	re.WriteString("$")
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		log.Printf("Invalid ignore pattern %q: %v", line, err)
+		return pattern, false
+	}
+	pattern.re = compiled
+	return pattern, true
+}
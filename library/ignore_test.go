@@ -0,0 +1,62 @@
+package library
+
+import "testing"
+
+func TestCompileIgnorePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.pdf", "a.pdf", false, true},
+		{"*.pdf", "dir/a.pdf", false, true},
+		{"*.pdf", "a.epub", false, false},
+		{"/drafts", "drafts", true, true},
+		{"/drafts", "books/drafts", true, false},
+		{"papers/*.pdf", "papers/a.pdf", false, true},
+		{"papers/*.pdf", "papers/old/a.pdf", false, false},
+		{"**/old", "a/b/old", true, true},
+		{"papers/**", "papers/a/b.pdf", false, true},
+		{"tmp/", "tmp", true, true},
+		{"tmp/", "tmp", false, false},
+		{"?.epub", "a.epub", false, true},
+		{"?.epub", "ab.epub", false, false},
+		{"[ab].epub", "b.epub", false, true},
+		{"[!ab].epub", "b.epub", false, false},
+		{`\#notes`, "#notes", false, true},
+		{`a\*b`, "a*b", false, true},
+		{`a\*b`, "axb", false, false},
+		{"Черновики", "Черновики", true, true},
+		{"Черновики", "Черновик", true, false},
+		{"?ерновики", "Черновики", true, true},
+		{`\Ч*.fb2`, "Чехов.fb2", false, true},
+		{"café*", "café au lait.epub", false, true},
+	}
+	for _, tt := range tests {
+		pattern, ok := compileIgnorePattern(tt.pattern)
+		if !ok {
+			t.Errorf("compileIgnorePattern(%q) failed", tt.pattern)
+			continue
+		}
+		got := pattern.re.MatchString(tt.path) && (!pattern.dirOnly || tt.isDir)
+		if got != tt.want {
+			t.Errorf("pattern %q on %q (dir %v) = %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestCompileIgnorePatternSkips(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		if _, ok := compileIgnorePattern(line); ok {
+			t.Errorf("compileIgnorePattern(%q) = ok, want skipped", line)
+		}
+	}
+}
+
+func TestCompileIgnorePatternNegate(t *testing.T) {
+	pattern, ok := compileIgnorePattern("!keep.pdf")
+	if !ok || !pattern.negate || !pattern.re.MatchString("keep.pdf") {
+		t.Errorf("compileIgnorePattern(%q) = %+v, %v", "!keep.pdf", pattern, ok)
+	}
+}
@@ -0,0 +1,225 @@
+package library
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// bookIndex keeps every book with its reading progress in memory, so a
+// search does not query SQLite and read the readers' files on every
+// keystroke. It is rebuilt by the first read after something changed: the
+// library database and the files of the progress sources are compared by
+// size and mtime, the directories of the sources are watched with inotify,
+// and the library invalidates the index itself after writing.
+type bookIndex struct {
+	mu    sync.RWMutex
+	built bool
+	books []Book
+	// forms are the normalised forms of the title and author of each book,
+	// and words their words, for fuzzy and typo matching
+	forms [][]normalizedText
+	words [][]string
+	opens map[string][]int64
+	stamp []fileStamp
+
+	// files are compared by stamp on every read
+	files []string
+	// changed is set when the watcher or the library saw a change
+	changed atomic.Bool
+	// unwatched is set when a directory could not be watched, which
+	// leaves no choice but to rebuild on every read
+	unwatched bool
+	watcher   *fsnotify.Watcher
+}
+
+type fileStamp struct {
+	size  int64
+	mtime time.Time
+}
+
+// indexSnapshot is the state of the index for one search. The slices must
+// not be modified.
+type indexSnapshot struct {
+	books []Book
+	forms [][]normalizedText
+	words [][]string
+}
+
+// sqliteFiles are the files SQLite writes a database to. Readers keep
+// writing to the -wal file until it is checkpointed.
+func sqliteFiles(db *sql.DB) []string {
+	var seq int
+	var name, file string
+	if err := db.QueryRow(`PRAGMA database_list`).Scan(&seq, &name, &file); err != nil || file == "" {
+		return nil
+	}
+	return []string{file, file + "-wal"}
+}
+
+// bookIndex returns the book index, starting to watch the readers' data
+// the first time.
+func (l *Library) bookIndex() *bookIndex {
+	l.indexOnce.Do(func() {
+		idx := &bookIndex{}
+		idx.files = sqliteFiles(l.DB)
+		var dirs []string
+		for _, source := range l.Sources {
+			watched, ok := source.(WatchedSource)
+			if !ok {
+				log.Printf("Cannot watch %s progress, the book index is rebuilt on every search", source.Name())
+				idx.unwatched = true
+				continue
+			}
+			files, sourceDirs := watched.WatchPaths()
+			idx.files = append(idx.files, files...)
+			dirs = append(dirs, sourceDirs...)
+		}
+		idx.watch(dirs)
+		l.index.Store(idx)
+	})
+	return l.index.Load()
+}
+
+// watch marks the index changed whenever something in one of dirs is
+// written, created or removed.
+func (idx *bookIndex) watch(dirs []string) {
+	if len(dirs) == 0 {
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Error watching reader data, the book index is rebuilt on every search: %v", err)
+		idx.unwatched = true
+		return
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			log.Printf("Error watching %s, the book index is rebuilt on every search: %v", dir, err)
+			idx.unwatched = true
+		}
+	}
+	idx.watcher = watcher
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Chmod) {
+					idx.changed.Store(true)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				// Events may have been lost
+				log.Printf("Error watching reader data: %v", err)
+				idx.changed.Store(true)
+			}
+		}
+	}()
+}
+
+func (idx *bookIndex) close() {
+	if idx.watcher != nil {
+		idx.watcher.Close()
+	}
+}
+
+func (idx *bookIndex) currentStamp() []fileStamp {
+	stamp := make([]fileStamp, len(idx.files))
+	for i, file := range idx.files {
+		if info, err := os.Stat(file); err == nil {
+			stamp[i] = fileStamp{size: info.Size(), mtime: info.ModTime()}
+		}
+	}
+	return stamp
+}
+
+// fresh reports whether the index can be used as is. It must be called
+// with idx.mu held.
+func (idx *bookIndex) fresh(stamp []fileStamp) bool {
+	if !idx.built || idx.unwatched || idx.changed.Load() {
+		return false
+	}
+	for i := range stamp {
+		if stamp[i] != idx.stamp[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidateIndex makes the next read rebuild the index. The library calls
+// it after writing, as the mtime of a database may not change between two
+// quick writes.
+func (l *Library) invalidateIndex() {
+	if idx := l.index.Load(); idx != nil {
+		idx.changed.Store(true)
+	}
+}
+
+// snapshot returns the indexed books, rebuilding the index if anything
+// changed, with their frecency as of now.
+func (l *Library) snapshot() (indexSnapshot, error) {
+	idx := l.bookIndex()
+	stamp := idx.currentStamp()
+
+	idx.mu.RLock()
+	if !idx.fresh(stamp) {
+		idx.mu.RUnlock()
+		if err := l.rebuildIndex(idx); err != nil {
+			return indexSnapshot{}, err
+		}
+		idx.mu.RLock()
+	}
+	defer idx.mu.RUnlock()
+
+	now := time.Now()
+	books := make([]Book, len(idx.books))
+	copy(books, idx.books)
+	for i := range books {
+		books[i].Frecency = l.frecency(idx.opens[books[i].FilePath], now)
+	}
+	return indexSnapshot{books: books, forms: idx.forms, words: idx.words}, nil
+}
+
+func (l *Library) rebuildIndex(idx *bookIndex) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	// Another reader may have rebuilt it meanwhile
+	stamp := idx.currentStamp()
+	if idx.fresh(stamp) {
+		return nil
+	}
+
+	// Changes from now on must trigger another rebuild
+	idx.changed.Store(false)
+	books, err := l.loadBooks()
+	if err != nil {
+		idx.changed.Store(true)
+		return err
+	}
+	opens, err := l.openEvents()
+	if err != nil {
+		log.Printf("could not get open history, continuing without it: %v", err)
+	}
+
+	forms := make([][]normalizedText, len(books))
+	words := make([][]string, len(books))
+	for i, book := range books {
+		forms[i] = foldedForms(book.Title + " " + book.Author)
+		words[i] = formWords(forms[i])
+	}
+	idx.books, idx.forms, idx.words, idx.opens, idx.stamp = books, forms, words, opens, stamp
+	idx.built = true
+	return nil
+}
@@ -0,0 +1,384 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"switcher/foliate"
+	"switcher/util"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ignoreStamps keeps the index of l from noticing changes by the files it
+// stamps, so that only invalidateIndex makes it rebuild.
+func ignoreStamps(l *Library) {
+	l.bookIndex().files = nil
+}
+
+func bookTitles(t *testing.T, l *Library) map[string]string {
+	t.Helper()
+	snap, err := l.snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := make(map[string]string)
+	for _, book := range snap.books {
+		titles[book.FilePath] = book.Title
+	}
+	return titles
+}
+
+func TestIndexInvalidation(t *testing.T) {
+	l, root := newTestLibrary(t)
+	ignoreStamps(l)
+
+	solaris := writeBook(t, root, "Stanisław Lem - Solaris.epub")
+	picnic := writeBook(t, root, "Strugatsky - Roadside Picnic.fb2")
+	if _, err := l.Scan(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if titles := bookTitles(t, l); len(titles) != 2 {
+		t.Fatalf("index after the scan = %v, want both books", titles)
+	}
+
+	// Writes the index is not told about are not seen
+	if _, err := l.DB.Exec(`UPDATE books SET title = 'Changed' WHERE filepath = ?`, solaris); err != nil {
+		t.Fatal(err)
+	}
+	if title := bookTitles(t, l)[solaris]; title != "Solaris" {
+		t.Errorf("title = %q before invalidating, want the indexed one", title)
+	}
+	l.invalidateIndex()
+	if title := bookTitles(t, l)[solaris]; title != "Changed" {
+		t.Errorf("title = %q after invalidating, want Changed", title)
+	}
+
+	if err := l.RecordOpen(picnic); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := l.snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, book := range snap.books {
+		if book.FilePath == picnic && book.Frecency == 0 {
+			t.Error("opening a book did not raise its frecency")
+		}
+	}
+
+	if err := l.RemoveBook(solaris); err != nil {
+		t.Fatal(err)
+	}
+	if titles := bookTitles(t, l); len(titles) != 1 {
+		t.Errorf("index after RemoveBook = %v", titles)
+	}
+
+	// The scan saves the book again
+	if _, err := l.Scan(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if titles := bookTitles(t, l); len(titles) != 2 {
+		t.Errorf("index after the second scan = %v", titles)
+	}
+
+	if err := l.ResetDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	if titles := bookTitles(t, l); len(titles) != 0 {
+		t.Errorf("index after ResetDatabase = %v", titles)
+	}
+}
+
+// TestIndexInvalidationByWatcher checks that books the watcher saves and
+// KOReader progress written next to them reach the index.
+func TestIndexInvalidationByWatcher(t *testing.T) {
+	l, root := newTestLibrary(t)
+	l.Sources = []ProgressSource{koreaderSource{}}
+	ignoreStamps(l)
+	startWatcher(t, l)
+
+	path := writeBook(t, root, "Stanisław Lem - Solaris.epub")
+	waitFor(t, "the new book", func() bool { return bookTitles(t, l)[path] != "" })
+
+	sidecar := filepath.Join(root, "Stanisław Lem - Solaris.sdr", "metadata.epub.lua")
+	if err := os.MkdirAll(filepath.Dir(sidecar), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sidecar, []byte(`return { ["percent_finished"] = 0.5 }`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the KOReader progress", func() bool {
+		snap, err := l.snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(snap.books) == 1 && snap.books[0].Percent == 50
+	})
+}
+
+// TestSnapshotConcurrent takes snapshots while books come and go; run it
+// with -race. Every snapshot must hold the forms and words of its own books.
+func TestSnapshotConcurrent(t *testing.T) {
+	l, root := newTestLibrary(t)
+	var paths []string
+	for i := 0; i < 20; i++ {
+		paths = append(paths, writeBook(t, root, fmt.Sprintf("Author %d - Title %d.epub", i, i)))
+	}
+	if _, err := l.Scan(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				snap, err := l.snapshot()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(snap.forms) != len(snap.books) || len(snap.words) != len(snap.books) {
+					errs <- fmt.Errorf("snapshot of %d books has %d forms and %d words",
+						len(snap.books), len(snap.forms), len(snap.words))
+					return
+				}
+				for j, book := range snap.books {
+					if want := foldedForms(book.Title + " " + book.Author)[0].text; snap.forms[j][0].text != want {
+						errs <- fmt.Errorf("book %q has the forms %q", book.Title, snap.forms[j][0].text)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		path := paths[i%len(paths)]
+		if err := l.RemoveBook(path); err != nil {
+			t.Error(err)
+		}
+		if err := l.RecordOpen(path); err != nil {
+			t.Error(err)
+		}
+		book := l.extractBook(path, fileState{})
+		book.Root = root
+		if err := l.saveBook(l.DB, book); err != nil {
+			t.Error(err)
+		}
+		l.invalidateIndex()
+	}
+	cancel()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if titles := bookTitles(t, l); len(titles) != len(paths) {
+		t.Errorf("index has %d books, want %d", len(titles), len(paths))
+	}
+}
+
+// benchQueries cover the kinds of search: listing everything, full text,
+// transliteration, fuzzy, typos and filters.
+var benchQueries = []string{
+	"",
+	"war",
+	"dostoevsky",
+	"tolstoy peace",
+	"garcia marq",
+	"lrod rings",
+	"author:king unread",
+	"format:epub sort:title",
+}
+
+// BenchmarkSearch measures SearchBooks on a synthetic library of 20000
+// books. Cold searches rebuild the book index first, like every search did
+// before there was one. Build with -tags sqlite_fts5 to include the
+// full-text index.
+func BenchmarkSearch(b *testing.B) {
+	l, err := generateLibrary(b.TempDir(), 20000)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	for _, query := range benchQueries {
+		b.Run(fmt.Sprintf("cold/%q", query), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				l.invalidateIndex()
+				if _, err := l.SearchBooks(query); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("warm/%q", query), func(b *testing.B) {
+			if _, err := l.SearchBooks(query); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := l.SearchBooks(query); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+var (
+	benchAuthors = []string{
+		"Фёдор Достоевский", "Лев Толстой", "Аркадий и Борис Стругацкие", "Михаил Булгаков",
+		"Gabriel García Márquez", "Stephen King", "J. R. R. Tolkien", "Ursula K. Le Guin",
+		"Stanisław Lem", "Jorge Luis Borges", "Haruki Murakami", "Virginia Woolf",
+		"Terry Pratchett", "Umberto Eco", "Italo Calvino", "Toni Morrison",
+	}
+	benchWords = []string{
+		"war", "peace", "night", "city", "river", "lord", "rings", "house", "garden", "stars",
+		"machine", "winter", "solitude", "picnic", "roadside", "crime", "punishment", "island",
+		"library", "mirror", "storm", "empire", "children", "silence", "ocean", "memory",
+		"война", "мир", "ночь", "город", "мастер", "пикник",
+	}
+	benchSubjects = []string{"fiction", "science fiction", "fantasy", "history", "classics", "horror"}
+	benchFormats  = []string{"pdf", "epub", "fb2"}
+)
+
+// generateLibrary writes a reproducible library of n books with empty book
+// files, and reading progress in zathura and Foliate for a third of them.
+func generateLibrary(dir string, n int) (*Library, error) {
+	rng := rand.New(rand.NewSource(1))
+	booksDir := filepath.Join(dir, "books")
+	foliateDir := filepath.Join(dir, "foliate")
+	for _, d := range []string{booksDir, filepath.Join(foliateDir, "library"), filepath.Join(dir, "zathura")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	dbPath := filepath.Join(dir, "library.sqlite")
+	db, err := util.LoadDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	l := &Library{DB: db}
+	if err := l.initSchema(dbPath); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	zathuraPath := filepath.Join(dir, "zathura", "bookmarks.sqlite")
+	zathuraDB, err := util.LoadDatabase(zathuraPath)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	defer zathuraDB.Close()
+	if _, err := zathuraDB.Exec(`CREATE TABLE IF NOT EXISTS fileinfo (file TEXT PRIMARY KEY, page INTEGER, time TIMESTAMP)`); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := fillLibrary(l, zathuraDB, rng, booksDir, foliateDir, n); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	for _, source := range []struct{ name, path string }{{"foliate", foliateDir}, {"zathura", zathuraPath}} {
+		source, err := NewProgressSource(source.name, source.path)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.Sources = append(l.Sources, source)
+	}
+	return l, nil
+}
+
+func fillLibrary(l *Library, zathuraDB *sql.DB, rng *rand.Rand, booksDir, foliateDir string, n int) error {
+	tx, err := l.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	zathuraTx, err := zathuraDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer zathuraTx.Rollback()
+
+	var uris [][]string
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		format := benchFormats[rng.Intn(len(benchFormats))]
+		path := filepath.Join(booksDir, fmt.Sprintf("%05d.%s", i, format))
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			return err
+		}
+
+		words := make([]string, 1+rng.Intn(4))
+		for j := range words {
+			words[j] = benchWords[rng.Intn(len(benchWords))]
+		}
+		book := scannedBook{Book: Book{
+			FilePath:    path,
+			Title:       strings.Join(words, " "),
+			Author:      benchAuthors[rng.Intn(len(benchAuthors))],
+			Format:      format,
+			Subjects:    benchSubjects[rng.Intn(len(benchSubjects))],
+			Description: "A novel about " + strings.Join(words, " and ") + ".",
+			Pages:       100 + rng.Intn(900),
+		}}
+		if err := l.saveBook(tx, book); err != nil {
+			return err
+		}
+
+		if rng.Intn(3) > 0 {
+			continue
+		}
+		page := 1 + rng.Intn(book.Pages)
+		lastRead := now.Add(-time.Duration(rng.Intn(90*24)) * time.Hour)
+		switch format {
+		case "pdf":
+			_, err = zathuraTx.Exec(`INSERT INTO fileinfo (file, page, time) VALUES (?, ?, ?)`,
+				path, page, lastRead.UTC().Format("2006-01-02 15:04:05"))
+		default:
+			id := fmt.Sprintf("book-%05d", i)
+			uris = append(uris, []string{id, path})
+			data, _ := json.Marshal(map[string]any{
+				"metadata": map[string]any{"title": book.Title, "author": []map[string]string{{"name": book.Author}}},
+				"progress": []int{page, book.Pages},
+			})
+			err = os.WriteFile(filepath.Join(foliateDir, id+".json"), data, 0644)
+		}
+		if err != nil {
+			return err
+		}
+		for j := rng.Intn(4); j > 0; j-- {
+			openedAt := now.Add(-time.Duration(rng.Intn(60*24)) * time.Hour).Unix()
+			if _, err := tx.Exec(`INSERT INTO open_events (filepath, opened_at) VALUES (?, ?)`, path, openedAt); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := json.Marshal(foliate.URIStore{URIs: uris})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(foliateDir, "library", "uri-store.json"), data, 0644); err != nil {
+		return err
+	}
+	if err := zathuraTx.Commit(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
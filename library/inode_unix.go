@@ -0,0 +1,15 @@
+//go:build unix
+
+package library
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -1,34 +1,86 @@
 package library
 
 import (
-	"bufio"
 	"database/sql"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
-	"switcher/foliate"
 	"switcher/util"
-	"switcher/zathura"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/lithammer/fuzzysearch/fuzzy"
 )
 
 type Book struct {
-	FilePath string `json:"filepath"`
-	Title    string `json:"title"`
-	Author   string `json:"author,omitempty"`
-	Format   string `json:"format"`
-	Page     int    `json:"page,omitempty"`
+	FilePath    string  `json:"filepath"`
+	Title       string  `json:"title"`
+	Author      string  `json:"author,omitempty"`
+	AuthorSort  string  `json:"authorSort,omitempty"`
+	Format      string  `json:"format"`
+	Page        int     `json:"page,omitempty"`
+	Pages       int     `json:"pages,omitempty"`
+	Language    string  `json:"language,omitempty"`
+	Identifiers string  `json:"identifiers,omitempty"`
+	Publisher   string  `json:"publisher,omitempty"`
+	Subjects    string  `json:"subjects,omitempty"`
+	Series      string  `json:"series,omitempty"`
+	SeriesIndex float64 `json:"seriesIndex,omitempty"`
+	Description string  `json:"description,omitempty"`
+	// Root is the label of the library root the book was found in
+	Root string `json:"root,omitempty"`
+	// LastRead is when a reader last saved the position, in unix seconds
+	LastRead int64 `json:"lastRead,omitempty"`
+	// Percent is how far a reader says the book has been read, from 0 to
+	// 100; 0 when it only knows the page
+	Percent float64 `json:"percent,omitempty"`
+	// Finished is set when the book was marked as finished in a reader
+	Finished   bool `json:"finished,omitempty"`
+	Highlights int  `json:"highlights,omitempty"`
+	// Location is where the reader of ProgressSource left off in its own
+	// terms, e.g. an EPUB CFI; empty when only the page is known
+	Location string `json:"location,omitempty"`
+	// ProgressSource is the reader the position comes from
+	ProgressSource string `json:"progressSource,omitempty"`
+	// Frecency grows with every time the book was opened from switcher and
+	// decays with the age of each open
+	Frecency float64 `json:"frecency,omitempty"`
 }
 
 type Library struct {
-	DB      *sql.DB
-	Zathura *zathura.Zathura
-	Foliate *foliate.Foliate
+	DB *sql.DB
+	// Sources are the readers the reading progress of books comes from
+	Sources   []ProgressSource
+	Extractor MetadataExtractor
+	// ScanWorkers is the number of files read in parallel; 0 means one per CPU
+	ScanWorkers int
+	// IgnorePatterns are gitignore-style patterns applied to every scan root
+	// in addition to its .ignore files
+	IgnorePatterns []string
+	// Roots are the directory trees scanned for books
+	Roots []Root
+	// Openers are tried before DefaultOpeners to open books
+	Openers []Opener
+	// FrecencyHalfLife is how long it takes an open to count half as much;
+	// 0 means DefaultFrecencyHalfLife
+	FrecencyHalfLife time.Duration
+	// MinSessionLength is how long a book must be open in a reader for the
+	// reading session to be kept; 0 means DefaultMinSessionLength
+	MinSessionLength time.Duration
+
+	// fts is set when SQLite has FTS5 and the full-text index is in use
+	fts bool
+
+	// scanMu keeps scans, rescans of the watcher and resets apart
+	scanMu sync.Mutex
+
+	index     atomic.Pointer[bookIndex]
+	indexOnce sync.Once
 }
 
 func GetLibraryDatabasePath() (string, error) {
@@ -46,289 +98,179 @@ func GetLibraryDatabasePath() (string, error) {
 	return filepath.Join(dbDir, "library.sqlite"), nil
 }
 
-func NewLibrary(dbPath string) (*Library, error) {
+// NewLibrary opens the library database. extractor decides how metadata is
+// read from new files; nil means DefaultExtractorChain. sources supply the
+// reading progress, see NewProgressSources.
+func NewLibrary(dbPath string, extractor MetadataExtractor, sources []ProgressSource) (*Library, error) {
 	db, err := util.LoadDatabase(dbPath)
 	if err != nil {
 		return nil, err
 	}
 
-	library := &Library{DB: db}
-	if err := library.initSchema(); err != nil {
-		return nil, err
-	}
-
-	zat, err := zathura.NewZathura()
-	if err != nil {
-		return nil, err
+	if extractor == nil {
+		extractor = DefaultExtractorChain()
 	}
-
-	foli, err := foliate.NewFoliate()
-	if err != nil {
+	library := &Library{DB: db, Extractor: extractor, Sources: sources}
+	if err := library.initSchema(dbPath); err != nil {
 		return nil, err
 	}
 
-	library.Zathura = zat
-	library.Foliate = foli
-
 	return library, nil
 }
 
-func (l *Library) initSchema() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS books (
-		filepath TEXT UNIQUE NOT NULL,
-		title TEXT NOT NULL,
-		author TEXT,
-		format TEXT NOT NULL
-	);
-	CREATE INDEX IF NOT EXISTS idx_books_filepath ON books(filepath);
-	`
-	_, err := l.DB.Exec(query)
+// initSchema migrates the database to the latest schema, backing it up
+// first when there is something to migrate.
+func (l *Library) initSchema(dbPath string) error {
+	version, err := SchemaVersion(l.DB)
 	if err != nil {
 		return err
 	}
-
-	// Add author column to existing table if it doesn't exist
-	_, err = l.DB.Exec(`ALTER TABLE books ADD COLUMN author TEXT DEFAULT ''`)
-	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
-		log.Printf("Warning: Could not add author column (may already exist): %v", err)
+	l.fts = ftsAvailable(l.DB)
+	if !l.fts {
+		log.Printf("SQLite was built without FTS5, searching without the full-text index")
+		if err := l.dropFTSTriggers(); err != nil {
+			return err
+		}
 	}
-
-	return nil
-}
-
-func (l *Library) ResetDatabase() error {
-	query := `DROP TABLE IF EXISTS books;`
-	_, err := l.DB.Exec(query)
-	if err != nil {
+	if _, err := Migrate(l.DB, MigrateOptions{BackupPath: BackupPath(dbPath, version)}); err != nil {
 		return err
 	}
-	return l.initSchema()
+	if !l.fts {
+		return nil
+	}
+	return l.initFTS()
 }
 
-func getIgnoredDirs(rootDir string) (map[string]struct{}, error) {
-	ignoredDirs := make(map[string]struct{})
-	ignoreFilePath := filepath.Join(rootDir, ".ignore")
+// ResetDatabase removes every book, keeping the schema.
+func (l *Library) ResetDatabase() error {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+	_, err := l.DB.Exec(`DELETE FROM books`)
+	l.invalidateIndex()
+	return err
+}
 
-	file, err := os.Open(ignoreFilePath)
+// SearchBooks finds books matching a query in the syntax of ParseQuery.
+// Words and phrases are looked up in the title, authors, series, subjects
+// and description and ranked with bm25. Titles that only match fuzzily
+// follow the full-text matches, then books whose title or author words are
+// a typo away from the words of the query. Matching ignores case and
+// diacritics and finds Cyrillic text by its Latin transliteration. Frecency
+// is blended into that order, and a query without words lists books in
+// continue reading order.
+func (l *Library) SearchBooks(query string) ([]Book, error) {
+	q, err := ParseQuery(query)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return ignoredDirs, nil
-		}
 		return nil, err
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line != "" {
-			ignoredDirs[line] = struct{}{}
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	return ignoredDirs, nil
+	return l.search(q)
 }
 
-func (l *Library) ScanDirectory(rootDir string) error {
-	supportedFormats := map[string]bool{
-		".pdf":  true,
-		".epub": true,
-		".fb2":  true,
-	}
-
-	ignoredDirs, err := getIgnoredDirs(rootDir)
+func (l *Library) search(q Query) ([]Book, error) {
+	snap, err := l.snapshot()
 	if err != nil {
-		return fmt.Errorf("error reading .ignore file: %w", err)
+		return nil, err
 	}
 
-	return filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			if filepath.Dir(path) == rootDir {
-				dirName := info.Name()
-				if _, ok := ignoredDirs[dirName]; ok {
-					log.Printf("Ignoring directory: %s\n", path)
-					return filepath.SkipDir
-				}
-			}
-			return nil
-		}
-
-		ext := strings.ToLower(filepath.Ext(path))
-		if !supportedFormats[ext] {
-			return nil
-		}
-
-		exists, err := l.bookExists(path)
-		if err != nil {
-			return err
-		}
+	books := snap.books
+	term := strings.TrimSpace(q.SearchText())
+	if term != "" {
+		books = l.matchText(snap, q, term)
+	}
 
-		if !exists {
-			return l.addBook(path)
+	var foundBooks []Book
+	for _, book := range books {
+		if q.Match(book) {
+			foundBooks = append(foundBooks, book)
 		}
-		return nil
-	})
-}
-
-func (l *Library) bookExists(filePath string) (bool, error) {
-	var count int
-	err := l.DB.QueryRow("SELECT COUNT(*) FROM books WHERE filepath = ?", filePath).Scan(&count)
-	return count > 0, err
-}
+	}
 
-func (l *Library) addBook(filePath string) error {
-	title := l.extractTitle(filePath)
-	author := l.extractAuthor(filePath)
-	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(filePath)), ".")
-	
-	authorInfo := ""
-	if author != "" {
-		authorInfo = " by " + author
+	switch {
+	case q.Sort.Key != "":
+		q.Sort.sortBooks(foundBooks)
+	case term != "":
+		sortByFrecency(foundBooks)
+	default:
+		sortContinueReading(foundBooks)
 	}
-	log.Printf("Adding book(%s): %s%s (%s)\n", filePath, title, authorInfo, format)
 
-	_, err := l.DB.Exec(`
-		INSERT INTO books (filepath, title, author, format)
-		VALUES (?, ?, ?, ?)`,
-		filePath, title, author, format)
-	return err
+	return foundBooks, nil
 }
 
-func (l *Library) extractTitle(filePath string) string {
-	cmd := exec.Command("exiftool", "-s", "-s", "-s", "-Title", filePath)
-	out, err := cmd.Output()
-	if err != nil {
-		log.Printf("Error executing exiftool on %s: %v", filePath, err)
-	}
-	title := strings.TrimSpace(string(out))
+// matchText returns the books matching the words and phrases of q, best
+// first.
+func (l *Library) matchText(snap indexSnapshot, q Query, term string) []Book {
+	allBooks := snap.books
+	var foundBooks []Book
+	found := make(map[string]bool)
 
-	if title == "" {
-		cmd := exec.Command("exiftool", filePath)
-		fullOut, err := cmd.Output()
+	if l.fts {
+		paths, err := l.searchFTS(q.ftsQuery())
 		if err != nil {
-			log.Printf("Error executing exiftool for full output on %s: %v", filePath, err)
-		} else {
-			scanner := bufio.NewScanner(strings.NewReader(string(fullOut)))
-			for scanner.Scan() {
-				line := scanner.Text()
-				if strings.Contains(strings.ToLower(line), "book-title:") {
-					parts := strings.SplitN(line, ":", 2)
-					if len(parts) > 1 {
-						title = strings.TrimSpace(parts[1])
-						break // Found it, no need to scan further
-					}
-				}
-			}
+			log.Printf("Full-text search for %q failed, falling back to fuzzy search: %v", term, err)
 		}
-	}
-
-	if title == "" {
-		title = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
-	}
-
-	return strings.TrimSpace(title)
-}
-
-func (l *Library) extractAuthor(filePath string) string {
-	// First try to get Author field directly
-	cmd := exec.Command("exiftool", "-s", "-s", "-s", "-Author", filePath)
-	out, err := cmd.Output()
-	if err != nil {
-		log.Printf("Error executing exiftool on %s: %v", filePath, err)
-	}
-	author := strings.TrimSpace(string(out))
-
-	// If Author is empty, try other common author fields
-	if author == "" {
-		authorFields := []string{"-Creator", "-Writer", "-dc:Creator"}
-		for _, field := range authorFields {
-			cmd := exec.Command("exiftool", "-s", "-s", "-s", field, filePath)
-			out, err := cmd.Output()
-			if err == nil {
-				author = strings.TrimSpace(string(out))
-				if author != "" {
-					break
-				}
+		byPath := make(map[string]int, len(allBooks))
+		for i, book := range allBooks {
+			byPath[book.FilePath] = i
+		}
+		for _, path := range paths {
+			if i, ok := byPath[path]; ok && !found[path] {
+				found[path] = true
+				foundBooks = append(foundBooks, allBooks[i])
 			}
 		}
 	}
 
-	// If still empty, scan full output for author-related fields
-	if author == "" {
-		cmd := exec.Command("exiftool", filePath)
-		fullOut, err := cmd.Output()
-		if err != nil {
-			log.Printf("Error executing exiftool for full output on %s: %v", filePath, err)
-		} else {
-			scanner := bufio.NewScanner(strings.NewReader(string(fullOut)))
-			for scanner.Scan() {
-				line := scanner.Text()
-				lowerLine := strings.ToLower(line)
-				if strings.Contains(lowerLine, "book-author:") || 
-				   strings.Contains(lowerLine, "author:") || 
-				   strings.Contains(lowerLine, "creator:") ||
-				   strings.Contains(lowerLine, "writer:") {
-					parts := strings.SplitN(line, ":", 2)
-					if len(parts) > 1 {
-						author = strings.TrimSpace(parts[1])
-						break // Found it, no need to scan further
-					}
+	// Fuzzy matches compare folded and transliterated forms of both sides,
+	// so "garcia" finds García, "dostoevsky" finds Достоевский and
+	// "Достоевский" finds Dostoevsky
+	var ranks fuzzy.Ranks
+	terms := foldedForms(term)
+	for i, book := range allBooks {
+		best := -1
+		for _, query := range terms {
+			for _, form := range snap.forms[i] {
+				if distance := fuzzy.RankMatch(query.text, form.text); distance >= 0 && (best < 0 || distance < best) {
+					best = distance
 				}
 			}
 		}
+		if best >= 0 {
+			ranks = append(ranks, fuzzy.Rank{Source: term, Target: book.Title, Distance: best, OriginalIndex: i})
+		}
 	}
-
-	return strings.TrimSpace(author)
-}
-
-func (l *Library) SearchBooks(term string) ([]Book, error) {
-	allBooks, err := l.GetAllBooks()
-	if err != nil {
-		return nil, err
-	}
-
-	if strings.TrimSpace(term) == "" {
-		return allBooks, nil
-	}
-
-	var titles []string
-	for _, book := range allBooks {
-		titles = append(titles, book.Title)
-	}
-
-	ranks := fuzzy.RankFindFold(term, titles)
 	sort.Sort(ranks)
 
-	var foundBooks []Book
 	for _, rank := range ranks {
-		foundBooks = append(foundBooks, allBooks[rank.OriginalIndex])
+		book := allBooks[rank.OriginalIndex]
+		if !found[book.FilePath] {
+			found[book.FilePath] = true
+			foundBooks = append(foundBooks, book)
+		}
 	}
 
-	return foundBooks, nil
+	for i, book := range allBooks {
+		if !found[book.FilePath] && typoMatch(term, snap.words[i]) {
+			found[book.FilePath] = true
+			foundBooks = append(foundBooks, book)
+		}
+	}
+
+	return foundBooks
 }
 
+// GetAllBooks returns every book with its reading progress, from the
+// in-memory index.
 func (l *Library) GetAllBooks() ([]Book, error) {
-	zathuraMap, err := l.Zathura.GetAllKnownBooks()
-	if err != nil {
-		log.Printf("could not get zathura books, continuing without them: %v", err)
-	}
-
-	foliateMap, err := l.Foliate.GetAllKnownBooks()
-	if err != nil {
-		log.Printf("could not get foliate books, continuing without them: %v", err)
-	}
+	snap, err := l.snapshot()
+	return snap.books, err
+}
 
+// loadBooks reads every book from the database and the readers.
+func (l *Library) loadBooks() ([]Book, error) {
 	rows, err := l.DB.Query(`
-		SELECT filepath, title, author, format
+		SELECT filepath, title, author, author_sort, format, language,
+			identifiers, publisher, subjects, series, series_index, description, pages, root
 		FROM books
 		ORDER BY title ASC`)
 	if err != nil {
@@ -339,32 +281,41 @@ func (l *Library) GetAllBooks() ([]Book, error) {
 	var books []Book
 	for rows.Next() {
 		var book Book
-		err := rows.Scan(&book.FilePath, &book.Title, &book.Author, &book.Format)
+		err := rows.Scan(&book.FilePath, &book.Title, &book.Author, &book.AuthorSort, &book.Format, &book.Language,
+			&book.Identifiers, &book.Publisher, &book.Subjects, &book.Series, &book.SeriesIndex, &book.Description, &book.Pages, &book.Root)
 		if err != nil {
 			return nil, err
 		}
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		if zathuraBook, ok := zathuraMap[book.FilePath]; ok {
-			book.Page = zathuraBook.Page
-		}
-
-		if foliateBook, ok := foliateMap[book.FilePath]; ok {
-			book.Title = foliateBook.Title
-			if foliateBook.Author != "" {
-				book.Author = foliateBook.Author
-			}
-			book.Page = foliateBook.Page
+	paths := make([]string, len(books))
+	for i, book := range books {
+		paths[i] = book.FilePath
+	}
+	known := make([]sourceProgress, 0, len(l.Sources))
+	for _, source := range l.Sources {
+		progress, err := source.KnownBooks(paths)
+		if err != nil {
+			log.Printf("could not get %s books, continuing without them: %v", source.Name(), err)
+			continue
 		}
-
-		books = append(books, book)
+		known = append(known, sourceProgress{source: source.Name(), books: progress})
+	}
+	for i := range books {
+		applyProgress(&books[i], known)
 	}
 
-	return books, rows.Err()
+	return books, nil
 }
 
 func (l *Library) GetBooksByFormat(format string) ([]Book, error) {
 	rows, err := l.DB.Query(`
-		SELECT filepath, title, author, format
+		SELECT filepath, title, author, author_sort, format, language,
+			identifiers, publisher, subjects, series, series_index, description, pages, root
 		FROM books
 		WHERE format = ?
 		ORDER BY title ASC`, format)
@@ -376,7 +327,8 @@ func (l *Library) GetBooksByFormat(format string) ([]Book, error) {
 	var books []Book
 	for rows.Next() {
 		var book Book
-		err := rows.Scan(&book.FilePath, &book.Title, &book.Author, &book.Format)
+		err := rows.Scan(&book.FilePath, &book.Title, &book.Author, &book.AuthorSort, &book.Format, &book.Language,
+			&book.Identifiers, &book.Publisher, &book.Subjects, &book.Series, &book.SeriesIndex, &book.Description, &book.Pages, &book.Root)
 		if err != nil {
 			return nil, err
 		}
@@ -388,30 +340,26 @@ func (l *Library) GetBooksByFormat(format string) ([]Book, error) {
 
 func (l *Library) RemoveBook(filePath string) error {
 	_, err := l.DB.Exec("DELETE FROM books WHERE filepath = ?", filePath)
+	l.invalidateIndex()
 	return err
 }
 
-func (l *Library) CleanupMissingFiles() error {
-	books, err := l.GetAllBooks()
-	if err != nil {
-		return err
+func (l *Library) Close() error {
+	if idx := l.index.Load(); idx != nil {
+		idx.close()
 	}
-
-	for _, book := range books {
-		if _, err := os.Stat(book.FilePath); os.IsNotExist(err) {
-			if err := l.RemoveBook(book.FilePath); err != nil {
-				return fmt.Errorf("failed to remove missing book %s: %w", book.FilePath, err)
+	for _, source := range l.Sources {
+		if closer, ok := source.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("Error closing %s progress: %v", source.Name(), err)
 			}
 		}
 	}
-
-	return nil
-}
-
-func (l *Library) Close() error {
-	// Close the bookmark extractor database connection if it exists
-	if l.Zathura != nil && l.Zathura.DB != nil {
-		l.Zathura.DB.Close()
+	// Stop the exiftool session of the extractor chain
+	if closer, ok := l.Extractor.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Error closing metadata extractor: %v", err)
+		}
 	}
 	return l.DB.Close()
 }
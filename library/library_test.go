@@ -0,0 +1,38 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestLibrary returns a library in a temporary directory with one root,
+// books, that books are read from by their file name.
+func newTestLibrary(t testing.TB) (*Library, string) {
+	t.Helper()
+	dir := t.TempDir()
+	root := filepath.Join(dir, "books")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLibrary(filepath.Join(dir, "library.sqlite"), FilenameExtractor{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	l.Roots = []Root{{Path: root}}
+	return l, root
+}
+
+// writeBook creates a book file below root with some content.
+func writeBook(t testing.TB, root, name string) string {
+	t.Helper()
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,473 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"switcher/epub"
+	"switcher/exiftool"
+	"switcher/fb2"
+	"switcher/pdf"
+)
+
+// Metadata is everything an extractor could learn about a book file.
+type Metadata struct {
+	Title       string
+	Authors     []string
+	AuthorSort  string
+	Language    string
+	Identifiers []string
+	Publisher   string
+	Subjects    []string
+	Series      string
+	SeriesIndex float64
+	Description string
+	Pages       int
+}
+
+// Confidence says how much an extractor trusts its result, from 0 to 1.
+type Confidence float64
+
+const (
+	ConfidenceNone   Confidence = 0
+	ConfidenceLow    Confidence = 0.3
+	ConfidenceMedium Confidence = 0.6
+	ConfidenceHigh   Confidence = 0.9
+)
+
+// ErrUnsupportedFormat is returned by extractors that cannot handle a file.
+// The chain skips them silently.
+var ErrUnsupportedFormat = errors.New("unsupported format")
+
+type MetadataExtractor interface {
+	Name() string
+	Extract(filePath string) (*Metadata, Confidence, error)
+}
+
+func (m *Metadata) merge(other *Metadata) {
+	if m.Title == "" {
+		m.Title = other.Title
+	}
+	if len(m.Authors) == 0 {
+		m.Authors = other.Authors
+		m.AuthorSort = other.AuthorSort
+	}
+	if m.Language == "" {
+		m.Language = other.Language
+	}
+	if len(m.Identifiers) == 0 {
+		m.Identifiers = other.Identifiers
+	}
+	if m.Publisher == "" {
+		m.Publisher = other.Publisher
+	}
+	if len(m.Subjects) == 0 {
+		m.Subjects = other.Subjects
+	}
+	if m.Series == "" {
+		m.Series = other.Series
+		m.SeriesIndex = other.SeriesIndex
+	}
+	if m.Description == "" {
+		m.Description = other.Description
+	}
+	if m.Pages == 0 {
+		m.Pages = other.Pages
+	}
+}
+
+func (m *Metadata) apply(book *Book) {
+	book.Title = m.Title
+	book.Author = strings.Join(m.Authors, ", ")
+	book.AuthorSort = m.AuthorSort
+	book.Language = m.Language
+	book.Identifiers = strings.Join(m.Identifiers, ", ")
+	book.Publisher = m.Publisher
+	book.Subjects = strings.Join(m.Subjects, ", ")
+	book.Series = m.Series
+	book.SeriesIndex = m.SeriesIndex
+	book.Description = m.Description
+	book.Pages = m.Pages
+}
+
+// ExtractorChain runs extractors in order until one of them finds a title.
+// Fields missing from earlier results are filled in by later extractors.
+type ExtractorChain struct {
+	Default []MetadataExtractor
+	Formats map[string][]MetadataExtractor
+
+	exiftool *exiftool.Session
+}
+
+// DefaultExtractorNames is used when switcher.toml does not configure a chain.
+var DefaultExtractorNames = []string{"native", "exiftool", "filename"}
+
+// NewExtractor creates a single extractor by name. Each exiftool extractor
+// gets its own session; use NewExtractorChain to share one.
+func NewExtractor(name string) (MetadataExtractor, error) {
+	return newExtractor(name, nil)
+}
+
+func newExtractor(name string, session *exiftool.Session) (MetadataExtractor, error) {
+	switch name {
+	case "native":
+		return NativeExtractor{}, nil
+	case "epub", "fb2", "pdf":
+		return NativeExtractor{Format: name}, nil
+	case "exiftool":
+		if session == nil {
+			session = exiftool.NewSession()
+		}
+		return ExiftoolExtractor{Session: session}, nil
+	case "filename":
+		return FilenameExtractor{}, nil
+	}
+	return nil, fmt.Errorf("unknown metadata extractor %q", name)
+}
+
+func (c *ExtractorChain) newExtractors(names []string) ([]MetadataExtractor, error) {
+	var extractors []MetadataExtractor
+	for _, name := range names {
+		extractor, err := newExtractor(name, c.exiftool)
+		if err != nil {
+			return nil, err
+		}
+		extractors = append(extractors, extractor)
+	}
+	return extractors, nil
+}
+
+// NewExtractorChain builds a chain from extractor names. formats overrides
+// the default order for individual formats, e.g. {"pdf": {"pdf", "filename"}}.
+func NewExtractorChain(defaults []string, formats map[string][]string) (*ExtractorChain, error) {
+	if len(defaults) == 0 {
+		defaults = DefaultExtractorNames
+	}
+	chain := &ExtractorChain{
+		Formats:  make(map[string][]MetadataExtractor),
+		exiftool: exiftool.NewSession(),
+	}
+
+	var err error
+	if chain.Default, err = chain.newExtractors(defaults); err != nil {
+		return nil, err
+	}
+	for format, names := range formats {
+		if chain.Formats[strings.ToLower(format)], err = chain.newExtractors(names); err != nil {
+			return nil, fmt.Errorf("format %s: %w", format, err)
+		}
+	}
+	return chain, nil
+}
+
+func DefaultExtractorChain() *ExtractorChain {
+	chain, _ := NewExtractorChain(DefaultExtractorNames, nil)
+	return chain
+}
+
+func (c *ExtractorChain) Name() string {
+	return "chain"
+}
+
+// Close stops the shared exiftool session, if it was ever started.
+func (c *ExtractorChain) Close() error {
+	return c.exiftool.Close()
+}
+
+var _ io.Closer = (*ExtractorChain)(nil)
+
+func (c *ExtractorChain) Extract(filePath string) (*Metadata, Confidence, error) {
+	extractors, ok := c.Formats[bookFormat(filePath)]
+	if !ok {
+		extractors = c.Default
+	}
+
+	var result *Metadata
+	confidence := ConfidenceNone
+	var errs []error
+	for _, extractor := range extractors {
+		metadata, conf, err := extractor.Extract(filePath)
+		if errors.Is(err, ErrUnsupportedFormat) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", extractor.Name(), err))
+			continue
+		}
+
+		if result == nil {
+			result = metadata
+			confidence = conf
+		} else {
+			if result.Title == "" && metadata.Title != "" {
+				confidence = conf
+			}
+			result.merge(metadata)
+		}
+		if result.Title != "" {
+			return result, confidence, nil
+		}
+	}
+
+	if result == nil {
+		if len(errs) == 0 {
+			return nil, ConfidenceNone, fmt.Errorf("no extractor for %s", filePath)
+		}
+		return nil, ConfidenceNone, errors.Join(errs...)
+	}
+	return result, confidence, nil
+}
+
+// NativeExtractor parses EPUB, FB2 and PDF files in-process. With Format set
+// it only handles that one format.
+type NativeExtractor struct {
+	Format string
+}
+
+func (e NativeExtractor) Name() string {
+	if e.Format != "" {
+		return e.Format
+	}
+	return "native"
+}
+
+func (e NativeExtractor) Extract(filePath string) (*Metadata, Confidence, error) {
+	format := bookFormat(filePath)
+	if format == "fb2.zip" {
+		format = "fb2"
+	}
+	if e.Format != "" && e.Format != format {
+		return nil, ConfidenceNone, ErrUnsupportedFormat
+	}
+
+	switch format {
+	case "epub":
+		metadata, err := epub.ReadMetadata(filePath)
+		if err != nil {
+			return nil, ConfidenceNone, err
+		}
+		return fromEpub(metadata), ConfidenceHigh, nil
+	case "fb2":
+		metadata, err := fb2.ReadMetadata(filePath)
+		if err != nil {
+			return nil, ConfidenceNone, err
+		}
+		return fromFB2(metadata), ConfidenceHigh, nil
+	case "pdf":
+		metadata, err := pdf.ReadMetadata(filePath)
+		if err != nil {
+			return nil, ConfidenceNone, err
+		}
+		// PDF Info is often filled with junk like "Microsoft Word - doc1"
+		return fromPDF(metadata), ConfidenceMedium, nil
+	}
+	return nil, ConfidenceNone, ErrUnsupportedFormat
+}
+
+func fromEpub(metadata *epub.Metadata) *Metadata {
+	var names, sortNames, identifiers []string
+	for _, author := range metadata.Authors() {
+		names = append(names, author.Name)
+		if author.FileAs != "" {
+			sortNames = append(sortNames, author.FileAs)
+		} else {
+			sortNames = append(sortNames, author.Name)
+		}
+	}
+	for _, id := range metadata.Identifiers {
+		if id.Scheme != "" {
+			identifiers = append(identifiers, id.Scheme+":"+id.Value)
+		} else {
+			identifiers = append(identifiers, id.Value)
+		}
+	}
+
+	return &Metadata{
+		Title:       metadata.Title,
+		Authors:     names,
+		AuthorSort:  strings.Join(sortNames, " & "),
+		Language:    metadata.Language,
+		Identifiers: identifiers,
+		Publisher:   metadata.Publisher,
+		Subjects:    metadata.Subjects,
+		Series:      metadata.Series,
+		SeriesIndex: metadata.SeriesIndex,
+		Description: metadata.Description,
+	}
+}
+
+func fromFB2(metadata *fb2.Metadata) *Metadata {
+	var names, sortNames []string
+	for _, author := range metadata.Authors {
+		names = append(names, author.Name())
+		sortNames = append(sortNames, author.SortName())
+	}
+
+	return &Metadata{
+		Title:       metadata.Title,
+		Authors:     names,
+		AuthorSort:  strings.Join(sortNames, " & "),
+		Language:    metadata.Language,
+		Subjects:    metadata.Genres,
+		Series:      metadata.Series,
+		SeriesIndex: float64(metadata.SeriesNumber),
+		Description: metadata.Annotation,
+	}
+}
+
+func fromPDF(metadata *pdf.Metadata) *Metadata {
+	result := &Metadata{
+		Title:       metadata.Title,
+		Authors:     metadata.Authors,
+		Description: metadata.Subject,
+		Pages:       metadata.Pages,
+	}
+	if metadata.Keywords != "" {
+		result.Subjects = []string{metadata.Keywords}
+	}
+	return result
+}
+
+// ExiftoolExtractor asks a long-running exiftool session for all tags of a
+// file in one round-trip. exiftool knows many more formats than the native
+// parsers.
+type ExiftoolExtractor struct {
+	Session *exiftool.Session
+}
+
+func (e ExiftoolExtractor) Name() string {
+	return "exiftool"
+}
+
+func (e ExiftoolExtractor) Close() error {
+	return e.Session.Close()
+}
+
+func (e ExiftoolExtractor) Extract(filePath string) (*Metadata, Confidence, error) {
+	tags, err := e.Session.Query(filePath)
+	if err != nil {
+		return nil, ConfidenceNone, err
+	}
+
+	metadata := &Metadata{
+		Title:       firstTag(tags, "Title", "BookTitle", "DescriptionTitle-infoBook-title"),
+		Authors:     exiftool.Strings(firstTagValue(tags, "Author", "Creator", "Writer", "BookAuthor")),
+		Language:    firstTag(tags, "Language"),
+		Publisher:   firstTag(tags, "Publisher"),
+		Subjects:    exiftool.Strings(tags["Subject"]),
+		Description: firstTag(tags, "Description"),
+	}
+	if pages, err := strconv.Atoi(exiftool.String(tags["PageCount"])); err == nil {
+		metadata.Pages = pages
+	}
+
+	// FB2 and other XML formats come through with long generated tag names
+	if metadata.Title == "" {
+		metadata.Title = firstTag(tags, xmlTitleTags...)
+	}
+	if len(metadata.Authors) == 0 {
+		metadata.Authors = exiftool.Strings(firstTagValue(tags, xmlAuthorTags...))
+	}
+	if len(metadata.Authors) == 0 {
+		metadata.Authors = xmlAuthorNames(tags)
+	}
+	return metadata, ConfidenceMedium, nil
+}
+
+// xmlTitleTags and xmlAuthorTags are the generated names of the title and
+// author of XML formats, the ones exiftool reads for FB2 first.
+var (
+	xmlTitleTags = []string{
+		"DescriptionTitle-infoBook-title",
+		"DescriptionSrc-title-infoBook-title",
+		"DescriptionPublish-infoBook-name",
+	}
+	xmlAuthorTags = []string{
+		"DescriptionTitle-infoAuthor",
+		"DescriptionTitle-infoAuthorNickname",
+		"DescriptionSrc-title-infoAuthor",
+		"DescriptionDocument-infoAuthor",
+	}
+)
+
+// xmlAuthorNames joins the FB2 author name parts exiftool lists apart.
+func xmlAuthorNames(tags map[string]any) []string {
+	prefix := "DescriptionTitle-infoAuthor"
+	first := exiftool.Strings(tags[prefix+"First-name"])
+	middle := exiftool.Strings(tags[prefix+"Middle-name"])
+	last := exiftool.Strings(tags[prefix+"Last-name"])
+	var authors []string
+	for i := range max(len(first), len(last)) {
+		var parts []string
+		for _, names := range [][]string{first, middle, last} {
+			if i < len(names) && names[i] != "" {
+				parts = append(parts, names[i])
+			}
+		}
+		if len(parts) > 0 {
+			authors = append(authors, strings.Join(parts, " "))
+		}
+	}
+	return authors
+}
+
+func firstTagValue(tags map[string]any, names ...string) any {
+	for _, name := range names {
+		if value, ok := tags[name]; ok && exiftool.String(value) != "" {
+			return value
+		}
+	}
+	return nil
+}
+
+func firstTag(tags map[string]any, names ...string) string {
+	return exiftool.String(firstTagValue(tags, names...))
+}
+
+// FilenameExtractor guesses title and author from names such as
+// "Arkady Strugatsky - Roadside Picnic (1972).epub".
+type FilenameExtractor struct{}
+
+func (e FilenameExtractor) Name() string {
+	return "filename"
+}
+
+var (
+	yearPattern    = regexp.MustCompile(`\s*[(\[]\d{4}[)\]]\s*`)
+	bracketPattern = regexp.MustCompile(`\s*\[[^\]]*\]\s*`)
+)
+
+func (e FilenameExtractor) Extract(filePath string) (*Metadata, Confidence, error) {
+	name := titleFromFilename(filePath)
+	name = strings.ReplaceAll(name, "_", " ")
+	name = yearPattern.ReplaceAllString(name, " ")
+	name = bracketPattern.ReplaceAllString(name, " ")
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		name = filepath.Base(filePath)
+	}
+
+	if author, title, ok := strings.Cut(name, " - "); ok && author != "" && title != "" && looksLikeName(author) {
+		return &Metadata{Title: title, Authors: []string{author}}, ConfidenceLow, nil
+	}
+	return &Metadata{Title: name}, ConfidenceLow / 2, nil
+}
+
+// looksLikeName accepts two to four words without digits, which is enough
+// to tell "Author - Title" apart from "Title - Subtitle" most of the time.
+func looksLikeName(s string) bool {
+	words := strings.Fields(s)
+	if len(words) < 2 || len(words) > 4 {
+		return false
+	}
+	for _, word := range words {
+		if strings.ContainsAny(word, "0123456789") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package library
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fakeExtractor returns a fixed result and records that it ran.
+type fakeExtractor struct {
+	name       string
+	metadata   *Metadata
+	confidence Confidence
+	err        error
+	calls      *[]string
+}
+
+func (e fakeExtractor) Name() string {
+	return e.name
+}
+
+func (e fakeExtractor) Extract(filePath string) (*Metadata, Confidence, error) {
+	*e.calls = append(*e.calls, e.name)
+	if e.err != nil {
+		return nil, ConfidenceNone, e.err
+	}
+	copied := *e.metadata
+	return &copied, e.confidence, nil
+}
+
+func TestExtractorChain(t *testing.T) {
+	errBroken := errors.New("broken file")
+	tests := []struct {
+		name       string
+		path       string
+		extractors []fakeExtractor
+		formats    map[string][]fakeExtractor
+		want       *Metadata
+		confidence Confidence
+		calls      []string
+		err        bool
+	}{
+		{
+			name: "stops at the first title",
+			path: "/books/a.epub",
+			extractors: []fakeExtractor{
+				{name: "first", metadata: &Metadata{Title: "Dune", Pages: 412}, confidence: ConfidenceHigh},
+				{name: "second", metadata: &Metadata{Title: "Other"}, confidence: ConfidenceLow},
+			},
+			want:       &Metadata{Title: "Dune", Pages: 412},
+			confidence: ConfidenceHigh,
+			calls:      []string{"first"},
+		},
+		{
+			name: "later extractors fill in missing fields",
+			path: "/books/a.pdf",
+			extractors: []fakeExtractor{
+				{name: "first", metadata: &Metadata{Authors: []string{"Frank Herbert"}, Pages: 412}, confidence: ConfidenceHigh},
+				{name: "second", metadata: &Metadata{Title: "Dune", Authors: []string{"Someone"}, Language: "en"}, confidence: ConfidenceLow},
+			},
+			want:       &Metadata{Title: "Dune", Authors: []string{"Frank Herbert"}, Language: "en", Pages: 412},
+			confidence: ConfidenceLow,
+			calls:      []string{"first", "second"},
+		},
+		{
+			name: "unsupported formats and errors are skipped",
+			path: "/books/a.djvu",
+			extractors: []fakeExtractor{
+				{name: "unsupported", err: ErrUnsupportedFormat},
+				{name: "broken", err: errBroken},
+				{name: "filename", metadata: &Metadata{Title: "a"}, confidence: ConfidenceLow},
+			},
+			want:       &Metadata{Title: "a"},
+			confidence: ConfidenceLow,
+			calls:      []string{"unsupported", "broken", "filename"},
+		},
+		{
+			name: "no title keeps what was found",
+			path: "/books/a.epub",
+			extractors: []fakeExtractor{
+				{name: "first", metadata: &Metadata{Pages: 10}, confidence: ConfidenceMedium},
+			},
+			want:       &Metadata{Pages: 10},
+			confidence: ConfidenceMedium,
+			calls:      []string{"first"},
+		},
+		{
+			name: "every extractor failing",
+			path: "/books/a.epub",
+			extractors: []fakeExtractor{
+				{name: "unsupported", err: ErrUnsupportedFormat},
+				{name: "broken", err: errBroken},
+			},
+			calls: []string{"unsupported", "broken"},
+			err:   true,
+		},
+		{
+			name: "formats override the default order",
+			path: "/books/a.FB2.zip",
+			extractors: []fakeExtractor{
+				{name: "default", metadata: &Metadata{Title: "Default"}, confidence: ConfidenceLow},
+			},
+			formats: map[string][]fakeExtractor{
+				"fb2.zip": {{name: "fb2", metadata: &Metadata{Title: "Пикник на обочине"}, confidence: ConfidenceHigh}},
+			},
+			want:       &Metadata{Title: "Пикник на обочине"},
+			confidence: ConfidenceHigh,
+			calls:      []string{"fb2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			chain := &ExtractorChain{Formats: make(map[string][]MetadataExtractor)}
+			for _, e := range tt.extractors {
+				e.calls = &calls
+				chain.Default = append(chain.Default, e)
+			}
+			for format, extractors := range tt.formats {
+				for _, e := range extractors {
+					e.calls = &calls
+					chain.Formats[format] = append(chain.Formats[format], e)
+				}
+			}
+
+			got, confidence, err := chain.Extract(tt.path)
+			if (err != nil) != tt.err {
+				t.Fatalf("Extract error = %v, want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || confidence != tt.confidence {
+				t.Errorf("Extract = %+v, %v, want %+v, %v", got, confidence, tt.want, tt.confidence)
+			}
+			if !reflect.DeepEqual(calls, tt.calls) {
+				t.Errorf("ran %v, want %v", calls, tt.calls)
+			}
+		})
+	}
+}
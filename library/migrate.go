@@ -0,0 +1,329 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Migration is one step of the library schema. Version is stored in
+// PRAGMA user_version once Up has run; every migration runs in its own
+// transaction together with that update.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+// migrations must stay ordered by version, and released migrations must
+// never change: add a new one instead.
+//
+// Databases created before versioning have user_version 0 and may already
+// contain any of the columns below, so the first migrations only add what
+// is missing.
+var migrations = []Migration{
+	{1, "create books table", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS books (
+				filepath TEXT UNIQUE NOT NULL,
+				title TEXT NOT NULL,
+				author TEXT,
+				format TEXT NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS idx_books_filepath ON books(filepath);`)
+		if err != nil {
+			return err
+		}
+		return addMissingColumns(tx, "books", "author TEXT DEFAULT ''")
+	}},
+	{2, "add book metadata columns", func(tx *sql.Tx) error {
+		return addMissingColumns(tx, "books",
+			"author_sort TEXT DEFAULT ''",
+			"language TEXT DEFAULT ''",
+			"identifiers TEXT DEFAULT ''",
+			"publisher TEXT DEFAULT ''",
+			"subjects TEXT DEFAULT ''",
+			"series TEXT DEFAULT ''",
+			"series_index REAL DEFAULT 0",
+			"description TEXT DEFAULT ''",
+			"pages INTEGER DEFAULT 0",
+		)
+	}},
+	{3, "add file state columns", func(tx *sql.Tx) error {
+		return addMissingColumns(tx, "books",
+			"size INTEGER DEFAULT 0",
+			"mtime INTEGER DEFAULT 0",
+			"inode INTEGER DEFAULT 0",
+		)
+	}},
+	{4, "add library root column", func(tx *sql.Tx) error {
+		return addMissingColumns(tx, "books", "root TEXT DEFAULT ''")
+	}},
+	// The full-text index refers to books by id, which unlike the implicit
+	// rowid survives VACUUM
+	{5, "add books id primary key", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE books_new (
+				id INTEGER PRIMARY KEY,
+				filepath TEXT UNIQUE NOT NULL,
+				title TEXT NOT NULL,
+				author TEXT DEFAULT '',
+				format TEXT NOT NULL,
+				author_sort TEXT DEFAULT '',
+				language TEXT DEFAULT '',
+				identifiers TEXT DEFAULT '',
+				publisher TEXT DEFAULT '',
+				subjects TEXT DEFAULT '',
+				series TEXT DEFAULT '',
+				series_index REAL DEFAULT 0,
+				description TEXT DEFAULT '',
+				pages INTEGER DEFAULT 0,
+				root TEXT DEFAULT '',
+				size INTEGER DEFAULT 0,
+				mtime INTEGER DEFAULT 0,
+				inode INTEGER DEFAULT 0
+			);
+			INSERT INTO books_new (id, filepath, title, author, format, author_sort, language,
+				identifiers, publisher, subjects, series, series_index, description, pages,
+				root, size, mtime, inode)
+			SELECT rowid, filepath, title, coalesce(author, ''), format, author_sort, language,
+				identifiers, publisher, subjects, series, series_index, description, pages,
+				root, size, mtime, inode
+			FROM books;
+			DROP TABLE books;
+			ALTER TABLE books_new RENAME TO books;
+			CREATE INDEX IF NOT EXISTS idx_books_filepath ON books(filepath);`)
+		return err
+	}},
+	{6, "create open events table", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE open_events (
+				id INTEGER PRIMARY KEY,
+				filepath TEXT NOT NULL,
+				opened_at INTEGER NOT NULL
+			);
+			CREATE INDEX idx_open_events_filepath ON open_events(filepath);`)
+		return err
+	}},
+	{7, "add transliterated search text", func(tx *sql.Tx) error {
+		if err := addMissingColumns(tx, "books", "search_text TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+		rows, err := tx.Query(`SELECT id, title, coalesce(author, '') FROM books`)
+		if err != nil {
+			return err
+		}
+		updates := make(map[int64]string)
+		for rows.Next() {
+			var id int64
+			var title, author string
+			if err := rows.Scan(&id, &title, &author); err != nil {
+				rows.Close()
+				return err
+			}
+			if text := transliterations(title, author); text != "" {
+				updates[id] = text
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for id, text := range updates {
+			if _, err := tx.Exec(`UPDATE books SET search_text = ? WHERE id = ?`, text, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{8, "create reading sessions table", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE reading_sessions (
+				id INTEGER PRIMARY KEY,
+				filepath TEXT NOT NULL,
+				opener TEXT NOT NULL DEFAULT '',
+				pid INTEGER NOT NULL,
+				started_at INTEGER NOT NULL,
+				ended_at INTEGER,
+				start_page INTEGER NOT NULL DEFAULT 0,
+				end_page INTEGER,
+				start_location TEXT NOT NULL DEFAULT '',
+				end_location TEXT
+			);
+			CREATE INDEX idx_reading_sessions_filepath ON reading_sessions(filepath);`)
+		return err
+	}},
+	{9, "add finished to reading sessions", func(tx *sql.Tx) error {
+		return addMissingColumns(tx, "reading_sessions", "end_finished INTEGER NOT NULL DEFAULT 0")
+	}},
+}
+
+// LatestSchemaVersion is the schema version this build migrates to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// addMissingColumns adds the columns that table does not have yet. Each
+// column is given as its definition, starting with the name.
+func addMissingColumns(tx *sql.Tx, table string, columns ...string) error {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[strings.ToLower(name)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		name, _, _ := strings.Cut(column, " ")
+		if existing[strings.ToLower(name)] {
+			continue
+		}
+		if _, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column); err != nil {
+			return fmt.Errorf("error adding column %s.%s: %w", table, name, err)
+		}
+	}
+	return nil
+}
+
+// SchemaStatus describes where a database stands in the migration history.
+type SchemaStatus struct {
+	Version int
+	Latest  int
+	Pending []Migration
+}
+
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+func GetSchemaStatus(db *sql.DB) (SchemaStatus, error) {
+	status := SchemaStatus{Latest: LatestSchemaVersion()}
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return status, err
+	}
+	status.Version = version
+	if version > status.Latest {
+		return status, fmt.Errorf("database schema version %d is newer than this switcher supports (%d)", version, status.Latest)
+	}
+	for _, m := range migrations {
+		if m.Version > version {
+			status.Pending = append(status.Pending, m)
+		}
+	}
+	return status, nil
+}
+
+// MigrateOptions control Migrate. With DryRun the pending migrations are
+// run in a single transaction that is rolled back, which checks that they
+// apply without changing anything. BackupPath, if set, receives a copy of
+// the database before the first migration of an existing database.
+type MigrateOptions struct {
+	DryRun     bool
+	BackupPath string
+}
+
+// Migrate brings the schema of db up to date and returns the migrations
+// that were applied, or that would have been with DryRun.
+func Migrate(db *sql.DB, opts MigrateOptions) ([]Migration, error) {
+	status, err := GetSchemaStatus(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(status.Pending) == 0 {
+		return nil, nil
+	}
+
+	if opts.DryRun {
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		for _, m := range status.Pending {
+			if err := m.Up(tx); err != nil {
+				return nil, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+			}
+		}
+		return status.Pending, nil
+	}
+
+	if opts.BackupPath != "" {
+		fresh, err := isEmptyDatabase(db)
+		if err != nil {
+			return nil, err
+		}
+		if !fresh {
+			if err := backupDatabase(db, opts.BackupPath); err != nil {
+				return nil, fmt.Errorf("error backing up database: %w", err)
+			}
+			log.Printf("Backed up library database to %s", opts.BackupPath)
+		}
+	}
+
+	var applied []Migration
+	for _, m := range status.Pending {
+		if err := applyMigration(db, m); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied library migration %d: %s", m.Version, m.Name)
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := m.Up(tx); err != nil {
+		return err
+	}
+	// PRAGMA does not take parameters
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, m.Version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func isEmptyDatabase(db *sql.DB) (bool, error) {
+	var count int
+	if err := db.QueryRow(`SELECT count(*) FROM sqlite_master`).Scan(&count); err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+func backupDatabase(db *sql.DB, path string) error {
+	// VACUUM INTO refuses to overwrite
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err := db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// BackupPath is where the database at dbPath is copied before migrating
+// from version.
+func BackupPath(dbPath string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", dbPath, version)
+}
@@ -0,0 +1,105 @@
+package library
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"switcher/util"
+)
+
+// TestMigrateUnversioned migrates a database from before versioning that
+// already has some of the later columns.
+func TestMigrateUnversioned(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "library.sqlite")
+	db, err := util.LoadDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE books (
+			filepath TEXT UNIQUE NOT NULL,
+			title TEXT NOT NULL,
+			author TEXT,
+			format TEXT NOT NULL,
+			language TEXT DEFAULT '',
+			pages INTEGER DEFAULT 0
+		);
+		INSERT INTO books (filepath, title, author, format, language, pages)
+		VALUES ('/books/picnic.fb2', 'Пикник на обочине', NULL, 'fb2', 'ru', 224),
+			('/books/dune.epub', 'Dune', 'Frank Herbert', 'epub', 'en', 412);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup := BackupPath(dbPath, 0)
+	applied, err := Migrate(db, MigrateOptions{BackupPath: backup})
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if len(applied) != LatestSchemaVersion() {
+		t.Errorf("applied %d migrations, want %d", len(applied), LatestSchemaVersion())
+	}
+	if version, err := SchemaVersion(db); err != nil || version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion = %d, %v, want %d", version, err, LatestSchemaVersion())
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Errorf("no backup: %v", err)
+	}
+
+	var (
+		id                     int64
+		author, language, text string
+		pages                  int
+		size, mtime, inode     int64
+		root                   string
+	)
+	err = db.QueryRow(`
+		SELECT id, author, language, pages, search_text, size, mtime, inode, root
+		FROM books WHERE filepath = '/books/picnic.fb2'`).
+		Scan(&id, &author, &language, &pages, &text, &size, &mtime, &inode, &root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 || author != "" || language != "ru" || pages != 224 {
+		t.Errorf("book = id %d, author %q, language %q, pages %d", id, author, language, pages)
+	}
+	if !strings.Contains(text, "piknik") {
+		t.Errorf("search_text = %q, want the transliterated title", text)
+	}
+
+	for _, table := range []string{"open_events", "reading_sessions"} {
+		var count int
+		if err := db.QueryRow(`SELECT count(*) FROM ` + table).Scan(&count); err != nil {
+			t.Errorf("table %s: %v", table, err)
+		}
+	}
+
+	// Nothing is left to do the second time
+	if applied, err := Migrate(db, MigrateOptions{}); err != nil || len(applied) != 0 {
+		t.Errorf("second Migrate = %d migrations, %v", len(applied), err)
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	db, err := util.LoadDatabase(filepath.Join(t.TempDir(), "library.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pending, err := Migrate(db, MigrateOptions{DryRun: true})
+	if err != nil || len(pending) != LatestSchemaVersion() {
+		t.Fatalf("Migrate = %d migrations, %v", len(pending), err)
+	}
+	if version, _ := SchemaVersion(db); version != 0 {
+		t.Errorf("SchemaVersion = %d after a dry run, want 0", version)
+	}
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE name = 'books'`).Scan(new(string))
+	if err != sql.ErrNoRows {
+		t.Errorf("dry run left the books table: %v", err)
+	}
+}
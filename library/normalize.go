@@ -0,0 +1,264 @@
+package library
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Search compares normalised forms of titles, authors and queries: lower
+// case without diacritics, plus Latin transliterations of Cyrillic text.
+// Every normalised rune remembers the original rune it came from, so a
+// match in a normalised form can be highlighted in the original string.
+
+type translitScheme int
+
+const (
+	// noTranslit only folds case and strips diacritics
+	noTranslit translitScheme = iota
+	// popularTranslit is the everyday English spelling, e.g. Достоевский
+	// becomes dostoevsky
+	popularTranslit
+	// bgnTranslit is BGN/PCGN without its apostrophes, e.g. Достоевский
+	// becomes dostoyevskiy
+	bgnTranslit
+)
+
+var translitSchemes = []translitScheme{popularTranslit, bgnTranslit}
+
+// normalizedText is a normalised form of a string. origin[i] is the index
+// of the original rune that produced rune i of text, with one extra entry
+// holding the original length, and ends[i] the index after the last rune it
+// stands for: transliterations can consume two runes, and combining marks
+// that were folded away belong to the letter before them.
+type normalizedText struct {
+	text   string
+	origin []int
+	ends   []int
+}
+
+// originalRange maps the rune range [start, end) of the normalised text to
+// the rune range of the original string it came from.
+func (n normalizedText) originalRange(start, end int) (int, int) {
+	if start >= end {
+		return n.origin[start], n.origin[start]
+	}
+	return n.origin[start], n.ends[end-1]
+}
+
+// foldedForms returns the distinct normalised forms of s: the folded form,
+// and the transliterations if s contains Cyrillic.
+func foldedForms(s string) []normalizedText {
+	forms := []normalizedText{normalize(s, noTranslit)}
+	if !hasCyrillic(s) {
+		return forms
+	}
+	for _, scheme := range translitSchemes {
+		form := normalize(s, scheme)
+		duplicate := false
+		for _, other := range forms {
+			if other.text == form.text {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			forms = append(forms, form)
+		}
+	}
+	return forms
+}
+
+// foldText is the folded form of s without transliteration.
+func foldText(s string) string {
+	if isASCII(s) {
+		return strings.ToLower(s)
+	}
+	return normalize(s, noTranslit).text
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+// transliterations returns the Latin forms of s separated by spaces, or ""
+// when s has no Cyrillic. It is stored with every book so the full-text
+// index finds Cyrillic titles by their Latin spelling.
+func transliterations(parts ...string) string {
+	var forms []string
+	seen := make(map[string]bool)
+	for _, part := range parts {
+		if !hasCyrillic(part) {
+			continue
+		}
+		for _, scheme := range translitSchemes {
+			form := normalize(part, scheme).text
+			if !seen[form] {
+				seen[form] = true
+				forms = append(forms, form)
+			}
+		}
+	}
+	return strings.Join(forms, " ")
+}
+
+// formWords returns the words of forms.
+func formWords(forms []normalizedText) []string {
+	var words []string
+	for _, form := range forms {
+		words = append(words, strings.FieldsFunc(form.text, isSeparator)...)
+	}
+	return words
+}
+
+func hasCyrillic(s string) bool {
+	for _, r := range s {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalize(s string, scheme translitScheme) normalizedText {
+	runes := []rune(s)
+	var b strings.Builder
+	origin := make([]int, 0, len(runes)+1)
+	ends := make([]int, 0, len(runes))
+	emit := func(out string, from, to int) {
+		for _, r := range out {
+			b.WriteRune(r)
+			origin = append(origin, from)
+			ends = append(ends, to)
+		}
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := unicode.ToLower(runes[i])
+		if scheme != noTranslit && unicode.Is(unicode.Cyrillic, r) {
+			out, consumed := transliterate(scheme, runes, i)
+			emit(out, i, i+consumed)
+			i += consumed - 1
+			continue
+		}
+		out := foldRune(r)
+		if out == "" && unicode.Is(unicode.Mn, r) && len(ends) > 0 && ends[len(ends)-1] == i {
+			ends[len(ends)-1] = i + 1
+		}
+		emit(out, i, i+1)
+	}
+	origin = append(origin, len(runes))
+	return normalizedText{text: b.String(), origin: origin, ends: ends}
+}
+
+// foldSpecial holds letters that do not decompose into a base letter and
+// combining marks.
+var foldSpecial = map[rune]string{
+	'ß': "ss",
+	'æ': "ae",
+	'œ': "oe",
+	'ø': "o",
+	'ł': "l",
+	'đ': "d",
+	'ð': "d",
+	'þ': "th",
+	'ı': "i",
+}
+
+func foldRune(r rune) string {
+	if out, ok := foldSpecial[r]; ok {
+		return out
+	}
+	if r < unicode.MaxASCII {
+		return string(r)
+	}
+	var b strings.Builder
+	for _, c := range norm.NFD.String(string(r)) {
+		if !unicode.Is(unicode.Mn, c) {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+var cyrillicLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	// Ukrainian and Belarusian
+	'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "w",
+}
+
+// transliterate converts the Cyrillic letter at runes[i] and returns the
+// Latin text with the number of runes it stands for.
+func transliterate(scheme translitScheme, runes []rune, i int) (string, int) {
+	r := unicode.ToLower(runes[i])
+	at := func(j int) rune {
+		if j < 0 || j >= len(runes) {
+			return 0
+		}
+		return unicode.ToLower(runes[j])
+	}
+
+	switch scheme {
+	case popularTranslit:
+		// Adjective endings read as -y: Достоевский, Белый
+		if (r == 'и' || r == 'ы') && at(i+1) == 'й' && !unicode.IsLetter(at(i+2)) {
+			return "y", 2
+		}
+	case bgnTranslit:
+		// е and ё are ye and yë at the start of a word and after vowels,
+		// ъ and ь, and the diacritic is folded away
+		if r == 'е' || r == 'ё' {
+			prev := at(i - 1)
+			if !unicode.IsLetter(prev) || strings.ContainsRune("аеёиоуыэюяъь", prev) {
+				return "ye", 1
+			}
+			return "e", 1
+		}
+	}
+
+	if out, ok := cyrillicLatin[r]; ok {
+		return out, 1
+	}
+	return foldRune(r), 1
+}
+
+// containsNormalized reports whether any form of s contains the folded
+// form of substr, or one of its transliterations.
+func containsNormalized(s, substr string) bool {
+	substr = strings.TrimSpace(substr)
+	if substr == "" {
+		return true
+	}
+	subs := foldedForms(substr)
+	contains := func(text string) bool {
+		for _, sub := range subs {
+			if strings.Contains(text, sub.text) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if contains(foldText(s)) {
+		return true
+	}
+	if !hasCyrillic(s) {
+		return false
+	}
+	for _, scheme := range translitSchemes {
+		if contains(normalize(s, scheme).text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,343 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Opener is a rule for opening books with a command. A rule applies to a
+// book when it matches every criterion it has; one without any applies to
+// every book.
+type Opener struct {
+	Name string
+	// Run is the command line, where {path} is replaced by the book's path,
+	// {page} by the page it was last read at, {location} by the position in
+	// the terms of the reader that saved it, e.g. an EPUB CFI, or the page,
+	// and {dir} by its directory. Arguments may be quoted like in a shell.
+	Run string
+	// Fallbacks are tried in order when the program of Run is not installed
+	Fallbacks []string
+	// Formats, e.g. ["pdf", "djvu"]; "fb2" includes zipped fb2 files
+	Formats []string
+	// Globs match the whole path or its last elements, e.g. "*/papers/*"
+	// or "*.djvu"
+	Globs []string
+	// Roots are labels or paths of library roots
+	Roots []string
+}
+
+// DefaultOpeners are used after the configured ones: zathura for PDFs and
+// Foliate for everything. Foliate takes no position and resumes on its own.
+var DefaultOpeners = []Opener{
+	{Name: "zathura", Run: "zathura --page {page} {path}", Formats: []string{"pdf"}},
+	{Name: "foliate", Run: "foliate {path}"},
+}
+
+var placeholders = map[string]bool{"path": true, "page": true, "location": true, "dir": true}
+
+// OpenResult tells where opening a book resumes.
+type OpenResult struct {
+	Opener string `json:"opener"`
+	// Source is the reader the position comes from, the one saved last;
+	// empty for books never read
+	Source   string `json:"source,omitempty"`
+	Page     int    `json:"page,omitempty"`
+	Location string `json:"location,omitempty"`
+	// Resumes is set when the opener is given the position, or opens the
+	// reader that saved it
+	Resumes bool `json:"resumes"`
+}
+
+// OpenCommand returns the command that opens the book at filePath with the
+// opener of that name, or the first one that applies and is installed when
+// name is empty.
+func (l *Library) OpenCommand(filePath, name string) (*exec.Cmd, OpenResult, error) {
+	book, err := l.Book(filePath)
+	if err != nil {
+		return nil, OpenResult{}, err
+	}
+
+	var lastErr error
+	for _, opener := range l.OpenersFor(book) {
+		if name != "" && opener.Name != name {
+			continue
+		}
+		cmd, run, err := opener.command(book)
+		if err != nil {
+			if name != "" {
+				return nil, OpenResult{}, err
+			}
+			log.Printf("Skipping opener: %v", err)
+			lastErr = err
+			continue
+		}
+		result := OpenResult{
+			Opener:   opener.Name,
+			Source:   book.ProgressSource,
+			Page:     book.Page,
+			Location: book.Location,
+		}
+		result.Resumes = book.ProgressSource != "" &&
+			(passesPosition(run) || filepath.Base(cmd.Path) == book.ProgressSource)
+		return cmd, result, nil
+	}
+	if lastErr != nil {
+		return nil, OpenResult{}, lastErr
+	}
+	if name != "" {
+		return nil, OpenResult{}, fmt.Errorf("opener %q does not open %s", name, filePath)
+	}
+	return nil, OpenResult{}, fmt.Errorf("no opener for %s", filePath)
+}
+
+// passesPosition reports whether the command line run uses {page} or
+// {location}.
+func passesPosition(run string) bool {
+	return strings.Contains(run, "{page}") || strings.Contains(run, "{location}")
+}
+
+// ValidateOpeners checks that openers have a name and valid commands.
+func ValidateOpeners(openers []Opener) error {
+	for _, opener := range openers {
+		if opener.Name == "" {
+			return fmt.Errorf("opener %q has no name", opener.Run)
+		}
+		for _, run := range append([]string{opener.Run}, opener.Fallbacks...) {
+			if _, err := splitCommand(run); err != nil {
+				return fmt.Errorf("opener %s: %w", opener.Name, err)
+			}
+		}
+		for _, glob := range opener.Globs {
+			if _, err := filepath.Match(glob, ""); err != nil {
+				return fmt.Errorf("opener %s: invalid glob %q: %w", opener.Name, glob, err)
+			}
+		}
+	}
+	return nil
+}
+
+// OpenersFor returns the openers that apply to book, configured ones first.
+// Default openers named like a configured one are left out.
+func (l *Library) OpenersFor(book Book) []Opener {
+	var found []Opener
+	names := make(map[string]bool)
+	configured := make(map[string]bool)
+	for _, opener := range l.Openers {
+		configured[opener.Name] = true
+		if !names[opener.Name] && l.opens(opener, book) {
+			names[opener.Name] = true
+			found = append(found, opener)
+		}
+	}
+	for _, opener := range DefaultOpeners {
+		if !configured[opener.Name] && l.opens(opener, book) {
+			found = append(found, opener)
+		}
+	}
+	return found
+}
+
+func (l *Library) opens(opener Opener, book Book) bool {
+	// Openers may handle formats the scan does not read, e.g. of files
+	// opened by path
+	if len(opener.Formats) > 0 && !includesFormat(opener.Formats, bookFormat(book.FilePath)) {
+		return false
+	}
+	if len(opener.Globs) > 0 {
+		matched := false
+		for _, glob := range opener.Globs {
+			if matchGlob(glob, book.FilePath) {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(opener.Roots) > 0 {
+		root, ok := l.rootFor(book.FilePath)
+		if !ok {
+			return false
+		}
+		matched := false
+		for _, name := range opener.Roots {
+			if name == root.Label || filepath.Clean(name) == root.Path {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchGlob reports whether glob matches path or the path of its last
+// elements, e.g. papers/a.pdf and a.pdf of /books/papers/a.pdf.
+func matchGlob(glob, path string) bool {
+	for {
+		if ok, _ := filepath.Match(glob, path); ok {
+			return true
+		}
+		i := strings.IndexRune(path, filepath.Separator)
+		if i < 0 {
+			return false
+		}
+		path = path[i+1:]
+	}
+}
+
+// Command returns the command that opens book: Run, or the first fallback
+// whose program is installed. The error wraps exec.ErrNotFound when none is.
+func (o Opener) Command(book Book) (*exec.Cmd, error) {
+	cmd, _, err := o.command(book)
+	return cmd, err
+}
+
+// command also returns the command line used, Run or a fallback.
+func (o Opener) command(book Book) (*exec.Cmd, string, error) {
+	var notFound error
+	for _, run := range append([]string{o.Run}, o.Fallbacks...) {
+		args, err := splitCommand(run)
+		if err != nil {
+			return nil, "", fmt.Errorf("opener %s: %w", o.Name, err)
+		}
+		program, err := exec.LookPath(expandPlaceholders(args[0], book))
+		if errors.Is(err, exec.ErrNotFound) {
+			notFound = err
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("opener %s: %w", o.Name, err)
+		}
+		for i := range args[1:] {
+			args[i+1] = expandPlaceholders(args[i+1], book)
+		}
+		return exec.Command(program, args[1:]...), run, nil
+	}
+	return nil, "", fmt.Errorf("opener %s: %w", o.Name, notFound)
+}
+
+// Book returns the book at filePath, or a book with only its path and
+// format when it is not in the library.
+func (l *Library) Book(filePath string) (Book, error) {
+	books, err := l.GetAllBooks()
+	if err != nil {
+		return Book{}, err
+	}
+	for _, book := range books {
+		if book.FilePath == filePath {
+			return book, nil
+		}
+	}
+	return Book{FilePath: filePath, Format: bookFormat(filePath)}, nil
+}
+
+// expandPlaceholders replaces the placeholders in arg with the values for
+// book. The page is 1 for books never read.
+func expandPlaceholders(arg string, book Book) string {
+	expanded, _ := replacePlaceholders(arg, func(name string) string {
+		switch name {
+		case "path":
+			return book.FilePath
+		case "page":
+			return strconv.Itoa(max(book.Page, 1))
+		case "location":
+			if book.Location != "" {
+				return book.Location
+			}
+			return strconv.Itoa(max(book.Page, 1))
+		case "dir":
+			return filepath.Dir(book.FilePath)
+		}
+		return ""
+	})
+	return expanded
+}
+
+func checkPlaceholders(arg string) error {
+	_, err := replacePlaceholders(arg, func(string) string { return "" })
+	return err
+}
+
+func replacePlaceholders(arg string, value func(name string) string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(arg, '{')
+		if start < 0 {
+			b.WriteString(arg)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(arg[start:], '}')
+		if end < 0 {
+			b.WriteString(arg)
+			return b.String(), nil
+		}
+		name := arg[start+1 : start+end]
+		if !placeholders[name] {
+			return "", fmt.Errorf("unknown placeholder {%s}", name)
+		}
+		b.WriteString(arg[:start])
+		b.WriteString(value(name))
+		arg = arg[start+end+1:]
+	}
+}
+
+// splitCommand splits a command line into arguments, keeping quoted
+// parts together. Backslashes escape the next character outside single
+// quotes.
+func splitCommand(run string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range run {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote in %q", run)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	for _, arg := range args {
+		if err := checkPlaceholders(arg); err != nil {
+			return nil, fmt.Errorf("%w in %q", err, run)
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,158 @@
+package library
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		run  string
+		want []string
+	}{
+		{"zathura --page {page} {path}", []string{"zathura", "--page", "{page}", "{path}"}},
+		{"  foliate\t{path}  ", []string{"foliate", "{path}"}},
+		{`sh -c "exec okular --page {page} \"$0\"" {path}`, []string{"sh", "-c", `exec okular --page {page} "$0"`, "{path}"}},
+		{`reader 'it''s' "a b"c`, []string{"reader", "its", "a bc"}},
+		{`reader 'a\b' "a\"b" a\ b`, []string{"reader", `a\b`, `a"b`, "a b"}},
+		{`reader "" ''`, []string{"reader", "", ""}},
+		{`reader --title="Война и мир"`, []string{"reader", "--title=Война и мир"}},
+		{`reader "{not closed"`, []string{"reader", "{not closed"}},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.run)
+		if err != nil {
+			t.Errorf("splitCommand(%q): %v", tt.run, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", tt.run, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCommandErrors(t *testing.T) {
+	for _, run := range []string{
+		"",
+		"   ",
+		`reader "{path}`,
+		`reader '{path}`,
+		`reader {path}\`,
+		"reader {file}",
+		`reader "--at={line}"`,
+	} {
+		if args, err := splitCommand(run); err == nil {
+			t.Errorf("splitCommand(%q) = %q, want an error", run, args)
+		}
+	}
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	odd := Book{FilePath: `/home/me/My Books/"Quoted" it's.pdf`, Page: 12}
+	epub := Book{FilePath: "/books/Солярис.epub", Location: "epubcfi(/6/4!/4/2/1:0)", Page: 3}
+	tests := []struct {
+		arg  string
+		book Book
+		want string
+	}{
+		{"{path}", odd, odd.FilePath},
+		{"{dir}", odd, `/home/me/My Books`},
+		{"--page={page}", odd, "--page=12"},
+		{"{page}", Book{FilePath: "/books/new.pdf"}, "1"},
+		{"{location}", epub, "epubcfi(/6/4!/4/2/1:0)"},
+		{"{location}", odd, "12"},
+		{"{location}", Book{FilePath: "/books/new.pdf"}, "1"},
+		{"{dir}/{path}", epub, "/books//books/Солярис.epub"},
+		{"plain", odd, "plain"},
+	}
+	for _, tt := range tests {
+		if got := expandPlaceholders(tt.arg, tt.book); got != tt.want {
+			t.Errorf("expandPlaceholders(%q, %q) = %q, want %q", tt.arg, tt.book.FilePath, got, tt.want)
+		}
+	}
+}
+
+// TestOpenerCommand checks that a path is passed as one argument whatever
+// it contains.
+func TestOpenerCommand(t *testing.T) {
+	book := Book{FilePath: `/home/me/My Books/"Quoted" it's; rm -rf.pdf`, Page: 7}
+	tests := []struct {
+		opener Opener
+		want   []string
+	}{
+		{Opener{Name: "echo", Run: "echo --page {page} {path}"}, []string{"--page", "7", book.FilePath}},
+		{Opener{Name: "echo", Run: `echo "{dir}" '{path}'`}, []string{"/home/me/My Books", book.FilePath}},
+		{Opener{Name: "fallback", Run: "no-such-reader-installed {path}", Fallbacks: []string{"echo -- {path}"}},
+			[]string{"--", book.FilePath}},
+	}
+	for _, tt := range tests {
+		cmd, err := tt.opener.Command(book)
+		if err != nil {
+			t.Errorf("Command(%q): %v", tt.opener.Run, err)
+			continue
+		}
+		if got := cmd.Args[1:]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Command(%q) args = %q, want %q", tt.opener.Run, got, tt.want)
+		}
+	}
+
+	if _, err := (Opener{Name: "missing", Run: "no-such-reader-installed {path}"}).Command(book); err == nil {
+		t.Error("Command of a program that is not installed succeeded")
+	}
+}
+
+func TestOpens(t *testing.T) {
+	l := &Library{Roots: []Root{
+		{Path: "/books", Label: "main"},
+		{Path: "/books/papers", Label: "papers"},
+		{Path: "/mnt/usb"},
+	}}
+	tests := []struct {
+		opener Opener
+		path   string
+		want   bool
+	}{
+		{Opener{}, "/anywhere/book.epub", true},
+		{Opener{Formats: []string{"pdf"}}, "/books/a.pdf", true},
+		{Opener{Formats: []string{"pdf"}}, "/books/A.PDF", true},
+		{Opener{Formats: []string{".PDF"}}, "/books/a.pdf", true},
+		{Opener{Formats: []string{"pdf"}}, "/books/a.epub", false},
+		{Opener{Formats: []string{"pdf", "djvu"}}, "/books/a.djvu", true},
+		{Opener{Formats: []string{"fb2"}}, "/books/a.fb2", true},
+		{Opener{Formats: []string{"fb2"}}, "/books/a.fb2.zip", true},
+		{Opener{Formats: []string{"fb2.zip"}}, "/books/a.fb2", false},
+		{Opener{Formats: []string{"zip"}}, "/books/a.fb2.zip", false},
+		{Opener{Globs: []string{"*/papers/*"}}, "/books/papers/a.pdf", true},
+		{Opener{Globs: []string{"*/papers/*"}}, "/books/a.pdf", false},
+		{Opener{Globs: []string{"/books/*"}}, "/books/a.pdf", true},
+		{Opener{Globs: []string{"/books/*"}}, "/books/papers/a.pdf", false},
+		{Opener{Globs: []string{"Lem - *"}}, "/books/Lem - Solaris.epub", true},
+		{Opener{Globs: []string{"[", "*.epub"}}, "/books/a.epub", true},
+		{Opener{Roots: []string{"papers"}}, "/books/papers/a.pdf", true},
+		{Opener{Roots: []string{"main"}}, "/books/papers/a.pdf", false},
+		{Opener{Roots: []string{"main"}}, "/books/a.pdf", true},
+		{Opener{Roots: []string{"/mnt/usb/"}}, "/mnt/usb/a.pdf", true},
+		{Opener{Roots: []string{"main"}}, "/elsewhere/a.pdf", false},
+		{Opener{Formats: []string{"pdf"}, Roots: []string{"papers"}}, "/books/papers/a.epub", false},
+	}
+	for _, tt := range tests {
+		if got := l.opens(tt.opener, Book{FilePath: tt.path}); got != tt.want {
+			t.Errorf("opener %+v opens %s = %v, want %v", tt.opener, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOpenersFor(t *testing.T) {
+	l := &Library{Openers: []Opener{
+		{Name: "sioyek", Run: "sioyek {path}", Formats: []string{"pdf"}},
+		{Name: "zathura", Run: "zathura {path}", Formats: []string{"djvu"}},
+	}}
+	var names []string
+	for _, opener := range l.OpenersFor(Book{FilePath: "/books/a.pdf"}) {
+		names = append(names, opener.Name)
+	}
+	// The configured zathura replaces the default one, also for PDFs
+	if want := []string{"sioyek", "foliate"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("OpenersFor = %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,91 @@
+package library
+
+import (
+	"sync"
+	"time"
+)
+
+// ScanProgress is reported periodically while a scan runs.
+type ScanProgress struct {
+	Root      string `json:"root"`
+	Seen      int    `json:"seen"`
+	Processed int    `json:"processed"`
+	Current   string `json:"current,omitempty"`
+	// WalkDone is set once every file under the root has been seen, which
+	// is when Seen becomes the final total
+	WalkDone bool `json:"walkDone"`
+	// ETASeconds estimates the time left from the extraction rate so far
+	ETASeconds float64 `json:"etaSeconds"`
+}
+
+// progressInterval limits how often progress callbacks fire.
+const progressInterval = 200 * time.Millisecond
+
+type progressTracker struct {
+	mu       sync.Mutex
+	callback func(ScanProgress)
+	progress ScanProgress
+	start    time.Time
+	last     time.Time
+}
+
+func newProgressTracker(root string, callback func(ScanProgress)) *progressTracker {
+	return &progressTracker{
+		callback: callback,
+		progress: ScanProgress{Root: root},
+		start:    time.Now(),
+	}
+}
+
+func (t *progressTracker) seen() {
+	t.update(func(p *ScanProgress) { p.Seen++ })
+}
+
+func (t *progressTracker) processed(path string) {
+	t.update(func(p *ScanProgress) {
+		p.Processed++
+		if path != "" {
+			p.Current = path
+		}
+	})
+}
+
+func (t *progressTracker) walkDone() {
+	t.update(func(p *ScanProgress) { p.WalkDone = true })
+}
+
+// finish reports the final state regardless of the rate limit.
+func (t *progressTracker) finish() {
+	if t == nil || t.callback == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.callback(t.snapshot())
+}
+
+func (t *progressTracker) update(change func(*ScanProgress)) {
+	if t == nil || t.callback == nil {
+		return
+	}
+	// The callback runs under the lock so that calls never overlap and
+	// arrive in order
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change(&t.progress)
+	now := time.Now()
+	if now.Sub(t.last) < progressInterval {
+		return
+	}
+	t.last = now
+	t.callback(t.snapshot())
+}
+
+func (t *progressTracker) snapshot() ScanProgress {
+	progress := t.progress
+	if progress.Processed > 0 && progress.Seen > progress.Processed {
+		perFile := time.Since(t.start).Seconds() / float64(progress.Processed)
+		progress.ETASeconds = perFile * float64(progress.Seen-progress.Processed)
+	}
+	return progress
+}
@@ -0,0 +1,430 @@
+package library
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query is a parsed books search, e.g.
+//
+//	author:strugatsky format:fb2 unread lang:ru "roadside picnic" sort:recent
+//
+// Words and quoted phrases are searched in the full-text index. field:value
+// filters narrow the results, a leading - negates a word, phrase or filter,
+// and sort: replaces relevance order; sort:-title reverses it.
+type Query struct {
+	Terms   []QueryTerm
+	Filters []QueryFilter
+	Sort    QuerySort
+}
+
+type QueryTerm struct {
+	Text   string
+	Phrase bool
+	Negate bool
+}
+
+// QueryFilter is a field:value filter. Numeric fields (page, pages and
+// progress) compare Number using Op, one of = < <= > >=.
+type QueryFilter struct {
+	Field  string
+	Value  string
+	Op     string
+	Number float64
+	Negate bool
+}
+
+// QuerySort orders the results by Key; the zero value keeps relevance.
+type QuerySort struct {
+	Key  string
+	Desc bool
+}
+
+// QueryError describes a malformed query. Pos is the byte offset of the
+// offending token.
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s (at position %d)", e.Msg, e.Pos+1)
+}
+
+var queryFields = map[string]string{
+	"author":   "author",
+	"title":    "title",
+	"format":   "format",
+	"tag":      "tag",
+	"tags":     "tag",
+	"subject":  "tag",
+	"series":   "series",
+	"lang":     "lang",
+	"language": "lang",
+	"root":     "root",
+	"status":   "status",
+	"progress": "progress",
+	"page":     "page",
+	"pages":    "pages",
+	"sort":     "sort",
+}
+
+var queryStatuses = map[string]string{
+	"unread":   "unread",
+	"new":      "unread",
+	"reading":  "reading",
+	"finished": "finished",
+	"read":     "finished",
+	"done":     "finished",
+}
+
+// querySorts maps sort keys to whether they sort descending by default.
+var querySorts = map[string]bool{
+	"relevance": false,
+	"title":     false,
+	"author":    false,
+	"series":    false,
+	"format":    false,
+	"pages":     false,
+	"progress":  true,
+	"recent":    true,
+	"frecency":  true,
+}
+
+// ParseQuery parses the books search syntax. The bare words unread,
+// reading and finished filter by status; quote them to search for the word.
+// Only the names of fields make a word before a colon a filter, others such
+// as "Re:Zero" are searched for.
+// Errors are *QueryError.
+func ParseQuery(input string) (Query, error) {
+	var q Query
+	pos := 0
+	for {
+		for pos < len(input) && isQuerySpace(input[pos]) {
+			pos++
+		}
+		if pos >= len(input) {
+			break
+		}
+		start := pos
+
+		negate := false
+		if input[pos] == '-' && pos+1 < len(input) && !isQuerySpace(input[pos+1]) {
+			negate = true
+			pos++
+		}
+
+		if input[pos] == '"' {
+			text, next, err := readQuoted(input, pos)
+			if err != nil {
+				return q, err
+			}
+			pos = next
+			if text = strings.TrimSpace(text); text != "" {
+				q.Terms = append(q.Terms, QueryTerm{Text: text, Phrase: true, Negate: negate})
+			}
+			continue
+		}
+
+		word := pos
+		for pos < len(input) && !isQuerySpace(input[pos]) && input[pos] != ':' && input[pos] != '"' {
+			pos++
+		}
+		name := input[word:pos]
+
+		if field, ok := queryFields[strings.ToLower(name)]; ok && pos < len(input) && input[pos] == ':' {
+			pos++
+			var value string
+			if pos < len(input) && input[pos] == '"' {
+				var err error
+				if value, pos, err = readQuoted(input, pos); err != nil {
+					return q, err
+				}
+			} else {
+				valueStart := pos
+				for pos < len(input) && !isQuerySpace(input[pos]) {
+					pos++
+				}
+				value = input[valueStart:pos]
+			}
+			value = strings.TrimSpace(value)
+			if value == "" {
+				return q, &QueryError{start, fmt.Sprintf("missing value for %s:", name)}
+			}
+			if err := q.addFilter(field, value, negate, start); err != nil {
+				return q, err
+			}
+			continue
+		}
+
+		// Anything else up to the next space is a plain word
+		for pos < len(input) && !isQuerySpace(input[pos]) {
+			pos++
+		}
+		text := input[word:pos]
+		if status, ok := queryStatuses[strings.ToLower(text)]; ok && status == strings.ToLower(text) {
+			q.Filters = append(q.Filters, QueryFilter{Field: "status", Value: status, Negate: negate})
+			continue
+		}
+		q.Terms = append(q.Terms, QueryTerm{Text: text, Negate: negate})
+	}
+	return q, nil
+}
+
+func isQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// readQuoted reads the quoted string starting at input[pos] and returns it
+// with the position after the closing quote.
+func readQuoted(input string, pos int) (string, int, error) {
+	end := strings.IndexByte(input[pos+1:], '"')
+	if end < 0 {
+		return "", pos, &QueryError{pos, "unterminated quote"}
+	}
+	return input[pos+1 : pos+1+end], pos + end + 2, nil
+}
+
+func (q *Query) addFilter(field, value string, negate bool, pos int) error {
+	filter := QueryFilter{Field: field, Value: value, Negate: negate}
+	switch field {
+	case "sort":
+		if negate {
+			return &QueryError{pos, "sort: cannot be negated"}
+		}
+		desc := false
+		if strings.HasPrefix(value, "-") {
+			desc = true
+			value = value[1:]
+		}
+		key := strings.ToLower(value)
+		defaultDesc, ok := querySorts[key]
+		if !ok {
+			return &QueryError{pos, fmt.Sprintf("unknown sort order %q", value)}
+		}
+		q.Sort = QuerySort{Key: key, Desc: defaultDesc != desc}
+		if key == "relevance" {
+			q.Sort = QuerySort{}
+		}
+		return nil
+
+	case "status":
+		status, ok := queryStatuses[strings.ToLower(value)]
+		if !ok {
+			return &QueryError{pos, fmt.Sprintf("unknown status %q, use unread, reading or finished", value)}
+		}
+		filter.Value = status
+
+	case "progress", "page", "pages":
+		filter.Op = "="
+		for _, op := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(value, op) {
+				filter.Op = op
+				value = value[len(op):]
+				break
+			}
+		}
+		if field == "progress" {
+			value = strings.TrimSuffix(value, "%")
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return &QueryError{pos, fmt.Sprintf("%s: expects a number, e.g. %s:>50", field, field)}
+		}
+		filter.Number = number
+
+	default:
+		filter.Value = strings.ToLower(value)
+	}
+	q.Filters = append(q.Filters, filter)
+	return nil
+}
+
+// SearchText is the positive words and phrases of the query, for fuzzy
+// matching.
+func (q Query) SearchText() string {
+	var parts []string
+	for _, term := range q.Terms {
+		if !term.Negate {
+			parts = append(parts, term.Text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// ftsQuery is the full-text part of the query: every positive word as a
+// prefix and every phrase as is.
+func (q Query) ftsQuery() string {
+	var parts []string
+	for _, term := range q.Terms {
+		if term.Negate {
+			continue
+		}
+		words := strings.FieldsFunc(term.Text, isSeparator)
+		if len(words) == 0 {
+			continue
+		}
+		if term.Phrase {
+			parts = append(parts, `"`+strings.Join(words, " ")+`"`)
+			continue
+		}
+		for _, word := range words {
+			parts = append(parts, `"`+word+`"*`)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Match reports whether book passes the filters and contains none of the
+// negated words.
+func (q Query) Match(book Book) bool {
+	for _, filter := range q.Filters {
+		if filter.match(book) == filter.Negate {
+			return false
+		}
+	}
+	for _, term := range q.Terms {
+		if term.Negate && book.containsText(term.Text) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f QueryFilter) match(book Book) bool {
+	switch f.Field {
+	case "author":
+		return containsNormalized(book.Author, f.Value) || containsNormalized(book.AuthorSort, f.Value)
+	case "title":
+		return containsNormalized(book.Title, f.Value)
+	case "series":
+		return containsNormalized(book.Series, f.Value)
+	case "tag":
+		for _, subject := range strings.Split(book.Subjects, ",") {
+			if containsNormalized(subject, f.Value) {
+				return true
+			}
+		}
+		return false
+	case "format":
+		format := strings.TrimPrefix(f.Value, ".")
+		return book.Format == format || format == "fb2" && book.Format == "fb2.zip"
+	case "lang":
+		language := strings.ToLower(book.Language)
+		return language == f.Value || strings.HasPrefix(language, f.Value+"-") || strings.HasPrefix(language, f.Value+"_")
+	case "root":
+		return strings.EqualFold(book.Root, f.Value)
+	case "status":
+		return book.Status() == f.Value
+	case "page":
+		return compare(float64(book.Page), f.Op, f.Number)
+	case "pages":
+		return book.Pages > 0 && compare(float64(book.Pages), f.Op, f.Number)
+	case "progress":
+		progress, ok := book.Progress()
+		return ok && compare(progress, f.Op, f.Number)
+	}
+	return false
+}
+
+func compare(value float64, op string, number float64) bool {
+	switch op {
+	case "<":
+		return value < number
+	case "<=":
+		return value <= number
+	case ">":
+		return value > number
+	case ">=":
+		return value >= number
+	}
+	return value == number
+}
+
+func (b Book) containsText(text string) bool {
+	for _, field := range []string{b.Title, b.Author, b.Series, b.Subjects, b.Description} {
+		if containsNormalized(field, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Progress is how far the book has been read in percent, known when the
+// book is unread or its page count is.
+func (b Book) Progress() (float64, bool) {
+	switch {
+	case b.Finished:
+		return 100, true
+	case b.Percent > 0:
+		return b.Percent, true
+	}
+	if b.Page == 0 {
+		return 0, true
+	}
+	if b.Pages == 0 {
+		return 0, false
+	}
+	return min(100, float64(b.Page)*100/float64(b.Pages)), true
+}
+
+// Status is unread, reading or finished.
+func (b Book) Status() string {
+	switch {
+	case b.Finished:
+		return "finished"
+	case b.Page == 0 && b.Percent == 0:
+		return "unread"
+	case b.Pages > 0 && b.Page >= b.Pages:
+		return "finished"
+	}
+	return "reading"
+}
+
+// sortBooks orders books in place; equal books keep their relevance order.
+func (s QuerySort) sortBooks(books []Book) {
+	if s.Key == "" {
+		return
+	}
+	less := func(a, b Book) bool {
+		switch s.Key {
+		case "title":
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		case "author":
+			return strings.ToLower(sortAuthor(a)) < strings.ToLower(sortAuthor(b))
+		case "series":
+			if !strings.EqualFold(a.Series, b.Series) {
+				return strings.ToLower(a.Series) < strings.ToLower(b.Series)
+			}
+			return a.SeriesIndex < b.SeriesIndex
+		case "format":
+			return a.Format < b.Format
+		case "pages":
+			return a.Pages < b.Pages
+		case "progress":
+			pa, _ := a.Progress()
+			pb, _ := b.Progress()
+			return pa < pb
+		case "recent":
+			return a.LastRead < b.LastRead
+		case "frecency":
+			return a.Frecency < b.Frecency
+		}
+		return false
+	}
+	sort.SliceStable(books, func(i, j int) bool {
+		if s.Desc {
+			return less(books[j], books[i])
+		}
+		return less(books[i], books[j])
+	})
+}
+
+func sortAuthor(b Book) string {
+	if b.AuthorSort != "" {
+		return b.AuthorSort
+	}
+	return b.Author
+}
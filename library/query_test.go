@@ -0,0 +1,85 @@
+package library
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Query
+	}{
+		{"", Query{}},
+		{"roadside picnic", Query{Terms: []QueryTerm{{Text: "roadside"}, {Text: "picnic"}}}},
+		{`"roadside picnic" -war`, Query{Terms: []QueryTerm{
+			{Text: "roadside picnic", Phrase: true},
+			{Text: "war", Negate: true},
+		}}},
+		{"author:Strugatsky format:FB2", Query{Filters: []QueryFilter{
+			{Field: "author", Value: "strugatsky"},
+			{Field: "format", Value: "fb2"},
+		}}},
+		{`Author:"Le Guin" -lang:ru`, Query{Filters: []QueryFilter{
+			{Field: "author", Value: "le guin"},
+			{Field: "lang", Value: "ru", Negate: true},
+		}}},
+		// Only the canonical statuses are bare word filters
+		{"Finished new", Query{
+			Terms:   []QueryTerm{{Text: "new"}},
+			Filters: []QueryFilter{{Field: "status", Value: "finished"}},
+		}},
+		{`"unread"`, Query{Terms: []QueryTerm{{Text: "unread", Phrase: true}}}},
+		{"status:read", Query{Filters: []QueryFilter{{Field: "status", Value: "finished"}}}},
+		{"progress:>50% pages:<=300 page:7", Query{Filters: []QueryFilter{
+			{Field: "progress", Value: ">50%", Op: ">", Number: 50},
+			{Field: "pages", Value: "<=300", Op: "<=", Number: 300},
+			{Field: "page", Value: "7", Op: "=", Number: 7},
+		}}},
+		{"sort:title", Query{Sort: QuerySort{Key: "title"}}},
+		{"sort:-recent", Query{Sort: QuerySort{Key: "recent"}}},
+		{"sort:recent sort:relevance", Query{}},
+		// Words before a colon that are not fields are searched for
+		{"Re:Zero", Query{Terms: []QueryTerm{{Text: "Re:Zero"}}}},
+		{"Dune: Messiah", Query{Terms: []QueryTerm{{Text: "Dune:"}, {Text: "Messiah"}}}},
+		{"война:мир", Query{Terms: []QueryTerm{{Text: "война:мир"}}}},
+		{"-", Query{Terms: []QueryTerm{{Text: "-"}}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseQuery(tt.input)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+	}{
+		{`"roadside picnic`, 0},
+		{`war author:`, 4},
+		{`author:"Le Guin`, 7},
+		{`status:abandoned`, 0},
+		{`sort:size`, 0},
+		{`-sort:title`, 0},
+		{`war progress:half`, 4},
+	}
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.input)
+		var queryErr *QueryError
+		if !errors.As(err, &queryErr) {
+			t.Errorf("ParseQuery(%q) = %v, want a *QueryError", tt.input, err)
+			continue
+		}
+		if queryErr.Pos != tt.pos {
+			t.Errorf("ParseQuery(%q) failed at %d, want %d: %v", tt.input, queryErr.Pos, tt.pos, err)
+		}
+	}
+}
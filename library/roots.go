@@ -0,0 +1,127 @@
+package library
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Root is a directory tree the library scans for books.
+type Root struct {
+	Path  string
+	Label string
+	// Formats limits the root to some formats, e.g. ["pdf"]; empty means
+	// every supported format. "fb2" includes zipped fb2 files.
+	Formats []string
+	// Ignore holds gitignore-style patterns used on top of the library's
+	// IgnorePatterns and the root's .ignore files
+	Ignore []string
+	// Removable roots may be missing or empty, e.g. an unmounted drive. Their
+	// books are kept until the root comes back.
+	Removable bool
+}
+
+// Name is the label books of the root are tagged with.
+func (r Root) Name() string {
+	if r.Label != "" {
+		return r.Label
+	}
+	return filepath.Base(r.Path)
+}
+
+func (r Root) accepts(format string) bool {
+	if !supportedFormats[format] {
+		return false
+	}
+	return len(r.Formats) == 0 || includesFormat(r.Formats, format)
+}
+
+// includesFormat reports whether format is one of formats, which may be
+// written with a dot and in any case. "fb2" includes zipped fb2 files.
+func includesFormat(formats []string, format string) bool {
+	for _, f := range formats {
+		f = strings.ToLower(strings.TrimPrefix(f, "."))
+		if f == format || f == "fb2" && format == "fb2.zip" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Root) contains(path string) bool {
+	return path == r.Path || strings.HasPrefix(path, strings.TrimSuffix(r.Path, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// online reports whether the books of the root can be trusted to be there.
+// A removable root that is missing or empty is offline; for other roots
+// that is an error.
+func (r Root) online() (bool, error) {
+	entries, err := os.ReadDir(r.Path)
+	if r.Removable {
+		return err == nil && len(entries) > 0, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error accessing scan root: %w", err)
+	}
+	return true, nil
+}
+
+// ValidateRoots checks that roots have a path and only use known formats.
+func ValidateRoots(roots []Root) error {
+	for _, root := range roots {
+		if root.Path == "" {
+			return fmt.Errorf("library root %q has no path", root.Label)
+		}
+		for _, format := range root.Formats {
+			format = strings.ToLower(strings.TrimPrefix(format, "."))
+			if !supportedFormats[format] {
+				return fmt.Errorf("library root %s: unsupported format %q", root.Path, format)
+			}
+		}
+	}
+	return nil
+}
+
+// rootFor returns the configured root a path belongs to. With nested roots
+// the innermost one wins.
+func (l *Library) rootFor(path string) (Root, bool) {
+	var found Root
+	ok := false
+	for _, root := range l.Roots {
+		if root.contains(path) && (!ok || len(root.Path) > len(found.Path)) {
+			found = root
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// root returns the configuration of the root at path, or a root with
+// default settings if it is not configured.
+func (l *Library) root(path string) Root {
+	path = filepath.Clean(path)
+	for _, root := range l.Roots {
+		if root.Path == path {
+			return root
+		}
+	}
+	return Root{Path: path}
+}
+
+// owns reports whether path is scanned as part of root rather than a root
+// nested inside it.
+func (l *Library) owns(root Root, path string) bool {
+	if !root.contains(path) {
+		return false
+	}
+	inner, ok := l.rootFor(path)
+	return !ok || inner.Path == root.Path || !root.contains(inner.Path)
+}
+
+func (l *Library) ignoreMatcher(root Root) *ignoreMatcher {
+	patterns := make([]string, 0, len(l.IgnorePatterns)+len(root.Ignore))
+	patterns = append(patterns, l.IgnorePatterns...)
+	patterns = append(patterns, root.Ignore...)
+	return newIgnoreMatcher(root.Path, patterns)
+}
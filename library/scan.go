@@ -0,0 +1,591 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"switcher/fb2"
+	"sync"
+	"time"
+)
+
+var supportedFormats = map[string]bool{
+	"pdf":     true,
+	"epub":    true,
+	"fb2":     true,
+	"fb2.zip": true,
+}
+
+// ScanReport summarises what a scan changed in the library.
+type ScanReport struct {
+	Added     int           `json:"added"`
+	Updated   int           `json:"updated"`
+	Removed   int           `json:"removed"`
+	Unchanged int           `json:"unchanged"`
+	Failed    int           `json:"failed"`
+	Duration  time.Duration `json:"duration"`
+}
+
+func (r ScanReport) String() string {
+	return fmt.Sprintf("%d added, %d updated, %d removed, %d unchanged, %d failed in %v",
+		r.Added, r.Updated, r.Removed, r.Unchanged, r.Failed, r.Duration.Round(time.Millisecond))
+}
+
+// fileState is what the scanner remembers about a file to tell whether it
+// changed since the last scan.
+type fileState struct {
+	Size  int64
+	Mtime int64
+	Inode uint64
+}
+
+func statOf(info os.FileInfo) fileState {
+	return fileState{
+		Size:  info.Size(),
+		Mtime: info.ModTime().UnixNano(),
+		Inode: fileInode(info),
+	}
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Scan brings the books of every configured root up to date, one root
+// after another. Removable roots that are offline are skipped and keep their
+// books. A failing root does not stop the others. Scans wait for the one
+// running, including those of the watcher.
+func (l *Library) Scan(ctx context.Context, progress func(ScanProgress)) (ScanReport, error) {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+	var total ScanReport
+	var errs []error
+	start := time.Now()
+	for _, root := range l.Roots {
+		report, err := l.scanRoot(ctx, root, progress)
+		total.Added += report.Added
+		total.Updated += report.Updated
+		total.Removed += report.Removed
+		total.Unchanged += report.Unchanged
+		total.Failed += report.Failed
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", root.Path, err))
+		}
+	}
+	total.Duration = time.Since(start)
+	return total, errors.Join(errs...)
+}
+
+// ScanDirectory brings the books under rootDir up to date. Files are only
+// re-read when their size, mtime or inode changed, renamed files keep their
+// row, and rows of files that disappeared are removed. rootDir uses the
+// settings of the configured root at that path, if any.
+//
+// A walker feeds paths to ScanWorkers extraction workers, and a single writer
+// stores the results in batched transactions. When ctx is cancelled the
+// open batch is rolled back and missing files are not removed, so the
+// database only ever contains complete batches.
+func (l *Library) ScanDirectory(ctx context.Context, rootDir string) (ScanReport, error) {
+	return l.ScanDirectoryWithProgress(ctx, rootDir, nil)
+}
+
+// ScanDirectoryWithProgress is ScanDirectory that also reports how far the
+// scan got. progress is called from several goroutines, but never
+// concurrently, and at most every 200ms plus once at the end.
+func (l *Library) ScanDirectoryWithProgress(ctx context.Context, rootDir string, progress func(ScanProgress)) (ScanReport, error) {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+	return l.scanRoot(ctx, l.root(rootDir), progress)
+}
+
+func (l *Library) scanRoot(ctx context.Context, root Root, progress func(ScanProgress)) (report ScanReport, err error) {
+	start := time.Now()
+	defer func() { report.Duration = time.Since(start) }()
+	tracker := newProgressTracker(root.Path, progress)
+	defer tracker.finish()
+
+	// A missing root (e.g. an unmounted drive) must not wipe the library
+	online, err := root.online()
+	if err != nil {
+		return report, err
+	}
+	if !online {
+		log.Printf("Library root %s is offline, keeping its books", root.Path)
+		// Still tag them, so they can be found by root while offline
+		_, err := l.knownFiles(root)
+		return report, err
+	}
+
+	known, err := l.knownFiles(root)
+	if err != nil {
+		return report, fmt.Errorf("error loading known files: %w", err)
+	}
+
+	jobs := make(chan scanJob, 64)
+	ops := make(chan scanOp, 64)
+
+	var walkReport ScanReport
+	var walkErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		walkReport, walkErr = l.walk(ctx, root, known, jobs, ops, tracker)
+	}()
+
+	var workersWg sync.WaitGroup
+	for i := 0; i < l.scanWorkers(); i++ {
+		workersWg.Add(1)
+		go func() {
+			defer workersWg.Done()
+			for job := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				book := l.extractBook(job.path, job.state)
+				book.Root = root.Name()
+				tracker.processed(job.path)
+				select {
+				case ops <- scanOp{kind: opSave, book: book, added: job.added}:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		workersWg.Wait()
+		close(ops)
+	}()
+
+	writeErr := l.writeOps(ctx, ops, &report)
+
+	report.Unchanged += walkReport.Unchanged
+	report.Failed += walkReport.Failed
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	if walkErr != nil {
+		return report, walkErr
+	}
+	return report, writeErr
+}
+
+func (l *Library) scanWorkers() int {
+	if l.ScanWorkers > 0 {
+		return l.ScanWorkers
+	}
+	return runtime.NumCPU()
+}
+
+type scanJob struct {
+	path  string
+	state fileState
+	added bool
+}
+
+type opKind int
+
+const (
+	opSave opKind = iota
+	opMove
+	opRemove
+)
+
+type scanOp struct {
+	kind    opKind
+	book    scannedBook
+	added   bool
+	path    string
+	oldPath string
+}
+
+// walk classifies every file of root against the known rows. New and changed files go to the extraction workers; moves and
+// removals are only decided once the whole tree has been seen. Known files
+// under directories that could not be read are kept, as they were not seen.
+func (l *Library) walk(ctx context.Context, root Root, known map[string]fileState, jobs chan<- scanJob, ops chan<- scanOp, tracker *progressTracker) (ScanReport, error) {
+	var report ScanReport
+	seen := make(map[string]bool)
+	var failed []string
+	ignore := l.ignoreMatcher(root)
+
+	// New paths with the identity of a known file may be moves
+	byIdentity := make(map[fileState]string)
+	for path, state := range known {
+		if state.Inode != 0 {
+			byIdentity[state] = path
+		}
+	}
+	var moveCandidates []scanJob
+
+	send := func(job scanJob) error {
+		select {
+		case jobs <- job:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	err := filepath.Walk(root.Path, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Error accessing %s: %v", path, err)
+			report.Failed++
+			failed = append(failed, path)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			if ignore.Match(path, true) {
+				log.Printf("Ignoring directory: %s\n", path)
+				return filepath.SkipDir
+			}
+			// Nested roots are scanned on their own
+			if !l.owns(root, path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !root.accepts(bookFormat(path)) || ignore.Match(path, false) {
+			return nil
+		}
+
+		seen[path] = true
+		tracker.seen()
+		state := statOf(info)
+		old, ok := known[path]
+		switch {
+		case !ok:
+			if oldPath, found := byIdentity[state]; found && bookFormat(oldPath) == bookFormat(path) {
+				moveCandidates = append(moveCandidates, scanJob{path: path, state: state, added: true})
+				return nil
+			}
+			return send(scanJob{path: path, state: state, added: true})
+		case old != state:
+			return send(scanJob{path: path, state: state})
+		default:
+			report.Unchanged++
+			tracker.processed("")
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	tracker.walkDone()
+
+	sendOp := func(op scanOp) error {
+		select {
+		case ops <- op:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	unreadable := func(path string) bool {
+		for _, dir := range failed {
+			if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	moved := make(map[string]bool)
+	for _, job := range moveCandidates {
+		oldPath := byIdentity[job.state]
+		if seen[oldPath] || moved[oldPath] || unreadable(oldPath) {
+			// Still there, so this is a copy or a hard link
+			if err := send(job); err != nil {
+				return report, err
+			}
+			continue
+		}
+		moved[oldPath] = true
+		if err := sendOp(scanOp{kind: opMove, path: job.path, oldPath: oldPath}); err != nil {
+			return report, err
+		}
+		tracker.processed(job.path)
+	}
+
+	for path := range known {
+		if !seen[path] && !moved[path] && !unreadable(path) {
+			if err := sendOp(scanOp{kind: opRemove, path: path}); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// writeBatchSize and writeFlushInterval bound how much work a cancelled
+// scan can lose and how long new books wait before they become visible.
+const (
+	writeBatchSize     = 200
+	writeFlushInterval = time.Second
+)
+
+// writeOps is the single writer of a scan. Operations are grouped into
+// transactions, and the report only counts operations that were committed.
+func (l *Library) writeOps(ctx context.Context, ops <-chan scanOp, report *ScanReport) error {
+	var tx *sql.Tx
+	var batch ScanReport
+	size := 0
+	var firstErr error
+
+	flush := func() {
+		if tx == nil {
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing scan batch: %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			report.Failed += size
+		} else {
+			l.invalidateIndex()
+			report.Added += batch.Added
+			report.Updated += batch.Updated
+			report.Removed += batch.Removed
+			report.Failed += batch.Failed
+		}
+		tx = nil
+		batch = ScanReport{}
+		size = 0
+	}
+
+	ticker := time.NewTicker(writeFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case op, ok := <-ops:
+			if !ok {
+				if ctx.Err() != nil {
+					if tx != nil {
+						tx.Rollback()
+					}
+					return ctx.Err()
+				}
+				flush()
+				return firstErr
+			}
+			if ctx.Err() != nil {
+				// Drain so producers can exit
+				continue
+			}
+
+			if tx == nil {
+				var err error
+				if tx, err = l.DB.BeginTx(ctx, nil); err != nil {
+					log.Printf("Error starting scan batch: %v", err)
+					batch.Failed++
+					continue
+				}
+			}
+			l.applyOp(tx, op, &batch)
+			size++
+			if size >= writeBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			if ctx.Err() == nil {
+				flush()
+			}
+		}
+	}
+}
+
+func (l *Library) applyOp(tx *sql.Tx, op scanOp, batch *ScanReport) {
+	switch op.kind {
+	case opSave:
+		if err := l.saveBook(tx, op.book); err != nil {
+			log.Printf("Error saving book %s: %v", op.book.FilePath, err)
+			batch.Failed++
+		} else if op.added {
+			batch.Added++
+		} else {
+			batch.Updated++
+		}
+	case opMove:
+		if err := moveBook(tx, op.oldPath, op.path); err != nil {
+			log.Printf("Error moving book %s to %s: %v", op.oldPath, op.path, err)
+			batch.Failed++
+		} else {
+			log.Printf("Book moved: %s -> %s", op.oldPath, op.path)
+			batch.Updated++
+		}
+	case opRemove:
+		if _, err := tx.Exec("DELETE FROM books WHERE filepath = ?", op.path); err != nil {
+			log.Printf("Error removing missing book %s: %v", op.path, err)
+			batch.Failed++
+		} else {
+			log.Printf("Removed missing book: %s", op.path)
+			batch.Removed++
+		}
+	}
+}
+
+// moveBook points the row of a book at its new path. The reading history
+// moves with the book.
+func moveBook(db execer, oldPath, path string) error {
+	for _, table := range []string{"books", "open_events", "reading_sessions"} {
+		if _, err := db.Exec("UPDATE "+table+" SET filepath = ? WHERE filepath = ?", path, oldPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// knownFiles returns the recorded state of every book of root, and retags
+// books whose root label changed since they were stored.
+func (l *Library) knownFiles(root Root) (map[string]fileState, error) {
+	rows, err := l.DB.Query(`SELECT filepath, root, size, mtime, inode FROM books`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	known := make(map[string]fileState)
+	var retag []string
+	for rows.Next() {
+		var path, label string
+		var state fileState
+		var inode int64
+		if err := rows.Scan(&path, &label, &state.Size, &state.Mtime, &inode); err != nil {
+			return nil, err
+		}
+		state.Inode = uint64(inode)
+		if l.owns(root, path) {
+			known[path] = state
+			if label != root.Name() {
+				retag = append(retag, path)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(retag) > 0 {
+		if err := l.retag(retag, root.Name()); err != nil {
+			return nil, fmt.Errorf("error retagging books: %w", err)
+		}
+	}
+	return known, nil
+}
+
+func (l *Library) retag(paths []string, label string) error {
+	tx, err := l.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, path := range paths {
+		if _, err := tx.Exec("UPDATE books SET root = ? WHERE filepath = ?", label, path); err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	l.invalidateIndex()
+	return err
+}
+
+// bookFormat returns the lowercase extension without the dot, keeping
+// compound extensions such as fb2.zip together.
+func bookFormat(filePath string) string {
+	if fb2.IsFB2(filePath) && strings.HasSuffix(strings.ToLower(filePath), ".zip") {
+		return "fb2.zip"
+	}
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(filePath)), ".")
+}
+
+type scannedBook struct {
+	Book
+	state fileState
+}
+
+func (l *Library) extractBook(filePath string, state fileState) scannedBook {
+	format := bookFormat(filePath)
+	book := Book{FilePath: filePath, Format: format}
+
+	metadata, confidence, err := l.Extractor.Extract(filePath)
+	if err != nil {
+		log.Printf("Error extracting metadata from %s: %v", filePath, err)
+	} else {
+		metadata.apply(&book)
+	}
+	if book.Title == "" {
+		book.Title = titleFromFilename(filePath)
+	}
+
+	authorInfo := ""
+	if book.Author != "" {
+		authorInfo = " by " + book.Author
+	}
+	log.Printf("Read book(%s): %s%s (%s, confidence %.1f)\n", filePath, book.Title, authorInfo, format, confidence)
+
+	return scannedBook{Book: book, state: state}
+}
+
+// saveBook inserts a book or replaces the row of the same file.
+func (l *Library) saveBook(db execer, book scannedBook) error {
+	_, err := db.Exec(`
+		INSERT INTO books (filepath, title, author, author_sort, format, language,
+			identifiers, publisher, subjects, series, series_index, description, pages,
+			root, search_text, size, mtime, inode)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(filepath) DO UPDATE SET
+			title = excluded.title,
+			author = excluded.author,
+			author_sort = excluded.author_sort,
+			format = excluded.format,
+			language = excluded.language,
+			identifiers = excluded.identifiers,
+			publisher = excluded.publisher,
+			subjects = excluded.subjects,
+			series = excluded.series,
+			series_index = excluded.series_index,
+			description = excluded.description,
+			pages = excluded.pages,
+			root = excluded.root,
+			search_text = excluded.search_text,
+			size = excluded.size,
+			mtime = excluded.mtime,
+			inode = excluded.inode`,
+		book.FilePath, book.Title, book.Author, book.AuthorSort, book.Format, book.Language,
+		book.Identifiers, book.Publisher, book.Subjects, book.Series, book.SeriesIndex, book.Description, book.Pages,
+		book.Root, transliterations(book.Title, book.Author), book.state.Size, book.state.Mtime, int64(book.state.Inode))
+	return err
+}
+
+func titleFromFilename(filePath string) string {
+	base := filepath.Base(filePath)
+	format := bookFormat(filePath)
+	if format == "" {
+		return base
+	}
+	return base[:len(base)-len(format)-1]
+}
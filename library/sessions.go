@@ -0,0 +1,211 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// sessionPollInterval is how often readers started before a restart are
+// checked for having exited, as they cannot be waited for.
+const sessionPollInterval = 5 * time.Second
+
+// DefaultMinSessionLength is used when Library.MinSessionLength is zero.
+const DefaultMinSessionLength = 10 * time.Second
+
+// ReadingSession is the time a book was open in a reader started from
+// switcher, with the positions it was opened and closed at.
+type ReadingSession struct {
+	ID       int64  `json:"id"`
+	FilePath string `json:"filepath"`
+	Opener   string `json:"opener,omitempty"`
+	PID      int    `json:"pid"`
+	// StartedAt and EndedAt are unix seconds; EndedAt is 0 while the reader
+	// is still running
+	StartedAt     int64  `json:"startedAt"`
+	EndedAt       int64  `json:"endedAt,omitempty"`
+	StartPage     int    `json:"startPage,omitempty"`
+	EndPage       int    `json:"endPage,omitempty"`
+	StartLocation string `json:"startLocation,omitempty"`
+	EndLocation   string `json:"endLocation,omitempty"`
+	// EndFinished is set when the book was finished at the end
+	EndFinished bool `json:"endFinished,omitempty"`
+}
+
+// Duration is how long the session lasted, or has lasted so far.
+func (s ReadingSession) Duration() time.Duration {
+	end := s.EndedAt
+	if end == 0 {
+		end = time.Now().Unix()
+	}
+	return time.Duration(end-s.StartedAt) * time.Second
+}
+
+// TrackSession records a session for a reader that was just started by
+// cmd to open a book, and ends it when the reader exits. Readers that hand
+// the book over to a running instance exit at once; sessions shorter than
+// MinSessionLength are dropped for that.
+func (l *Library) TrackSession(cmd *exec.Cmd, result OpenResult, filePath string) error {
+	if cmd.Process == nil {
+		return fmt.Errorf("reader for %s was not started", filePath)
+	}
+	startedAt := time.Now().Unix()
+	res, err := l.DB.Exec(`
+		INSERT INTO reading_sessions (filepath, opener, pid, started_at, start_page, start_location)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		filePath, result.Opener, cmd.Process.Pid, startedAt, result.Page, result.Location)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("Reader of %s exited: %v", filePath, err)
+		}
+		l.endSession(id, filePath, startedAt, time.Now().Unix())
+	}()
+	return nil
+}
+
+// ResumeSessions re-attaches to the readers of sessions that were still
+// running when switcher last exited. Sessions whose reader has exited
+// meanwhile end when the reader last saved the position, as that is
+// usually when it was closed. Re-attached readers are polled until they
+// exit or ctx is cancelled.
+func (l *Library) ResumeSessions(ctx context.Context) error {
+	sessions, err := l.readingSessions(`WHERE ended_at IS NULL`)
+	if err != nil {
+		return err
+	}
+
+	var running []ReadingSession
+	for _, session := range sessions {
+		if readerRunning(session) {
+			running = append(running, session)
+			continue
+		}
+		endedAt := session.StartedAt
+		if book, err := l.Book(session.FilePath); err == nil && book.LastRead > session.StartedAt {
+			endedAt = book.LastRead
+		}
+		l.endSession(session.ID, session.FilePath, session.StartedAt, endedAt)
+	}
+	if len(running) == 0 {
+		return nil
+	}
+	log.Printf("Re-attached to %d running readers", len(running))
+
+	go func() {
+		ticker := time.NewTicker(sessionPollInterval)
+		defer ticker.Stop()
+		for len(running) > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			now := time.Now().Unix()
+			alive := running[:0]
+			for _, session := range running {
+				if readerRunning(session) {
+					alive = append(alive, session)
+				} else {
+					l.endSession(session.ID, session.FilePath, session.StartedAt, now)
+				}
+			}
+			running = alive
+		}
+	}()
+	return nil
+}
+
+// readerRunning reports whether the process of a session is still the
+// reader it started: the PID may have been reused since, so its command
+// line must name the book. A command line that can't be read counts as
+// another process.
+func readerRunning(session ReadingSession) bool {
+	process, err := os.FindProcess(session.PID)
+	if err != nil || process.Signal(syscall.Signal(0)) != nil {
+		return false
+	}
+	cmdline, err := os.ReadFile("/proc/" + strconv.Itoa(session.PID) + "/cmdline")
+	return err == nil && bytes.Contains(cmdline, []byte(session.FilePath))
+}
+
+func (l *Library) minSessionLength() time.Duration {
+	if l.MinSessionLength > 0 {
+		return l.MinSessionLength
+	}
+	return DefaultMinSessionLength
+}
+
+// endSession closes a session, sampling the progress sources for where the
+// reader left off. Sessions too short to have been read in are removed.
+func (l *Library) endSession(id int64, filePath string, startedAt, endedAt int64) {
+	// The reader saved its position on the way out, don't wait for the
+	// watchers to notice
+	l.invalidateIndex()
+	if time.Duration(endedAt-startedAt)*time.Second < l.minSessionLength() {
+		if _, err := l.DB.Exec(`DELETE FROM reading_sessions WHERE id = ?`, id); err != nil {
+			log.Printf("Error removing reading session of %s: %v", filePath, err)
+		}
+		return
+	}
+	var endPage int
+	var endLocation string
+	var finished bool
+	if book, err := l.Book(filePath); err != nil {
+		log.Printf("Error reading the progress of %s: %v", filePath, err)
+	} else {
+		endPage, endLocation, finished = book.Page, book.Location, book.Status() == "finished"
+	}
+
+	_, err := l.DB.Exec(`UPDATE reading_sessions SET ended_at = ?, end_page = ?, end_location = ?, end_finished = ? WHERE id = ?`,
+		endedAt, endPage, endLocation, finished, id)
+	if err != nil {
+		log.Printf("Error ending reading session of %s: %v", filePath, err)
+	}
+}
+
+// GetReadingSessions returns the sessions of a book, the latest first.
+func (l *Library) GetReadingSessions(filePath string) ([]ReadingSession, error) {
+	return l.readingSessions(`WHERE filepath = ? ORDER BY started_at DESC`, filePath)
+}
+
+func (l *Library) readingSessions(where string, args ...any) ([]ReadingSession, error) {
+	rows, err := l.DB.Query(`
+		SELECT id, filepath, opener, pid, started_at, ended_at, start_page, end_page, start_location, end_location, end_finished
+		FROM reading_sessions `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []ReadingSession
+	for rows.Next() {
+		var session ReadingSession
+		var endedAt, endPage sql.NullInt64
+		var endLocation sql.NullString
+		err := rows.Scan(&session.ID, &session.FilePath, &session.Opener, &session.PID, &session.StartedAt,
+			&endedAt, &session.StartPage, &endPage, &session.StartLocation, &endLocation, &session.EndFinished)
+		if err != nil {
+			return nil, err
+		}
+		session.EndedAt = endedAt.Int64
+		session.EndPage = int(endPage.Int64)
+		session.EndLocation = endLocation.String
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,68 @@
+package library
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// trackReader starts cmd as the reader of path and waits for its session to
+// end.
+func trackReader(t *testing.T, l *Library, cmd *exec.Cmd, path string) []ReadingSession {
+	t.Helper()
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start %s: %v", cmd.Path, err)
+	}
+	if err := l.TrackSession(cmd, OpenResult{Opener: "test"}, path); err != nil {
+		t.Fatal(err)
+	}
+	var sessions []ReadingSession
+	waitFor(t, "the session to end", func() bool {
+		var err error
+		if sessions, err = l.GetReadingSessions(path); err != nil {
+			t.Fatal(err)
+		}
+		return len(sessions) == 0 || sessions[0].EndedAt != 0
+	})
+	return sessions
+}
+
+func TestTrackSessionDropsHandOffs(t *testing.T) {
+	l, root := newTestLibrary(t)
+	path := writeBook(t, root, "Stanisław Lem - Solaris.epub")
+
+	// A reader that hands the book over to a running instance exits at once
+	if sessions := trackReader(t, l, exec.Command("true"), path); len(sessions) != 0 {
+		t.Errorf("sessions = %+v, want none", sessions)
+	}
+
+	l.MinSessionLength = time.Second
+	sessions := trackReader(t, l, exec.Command("sleep", "1"), path)
+	if len(sessions) != 1 || sessions[0].Duration() < time.Second {
+		t.Errorf("sessions = %+v, want one of a second", sessions)
+	}
+}
+
+func TestResumeSessionsDropsShort(t *testing.T) {
+	l, root := newTestLibrary(t)
+	path := writeBook(t, root, "Stanisław Lem - Solaris.epub")
+
+	// The reader exited while switcher was not running, without saving a
+	// position to tell when
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run true: %v", err)
+	}
+	_, err := l.DB.Exec(`INSERT INTO reading_sessions (filepath, pid, started_at) VALUES (?, ?, ?)`,
+		path, cmd.Process.Pid, time.Now().Add(-time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.ResumeSessions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, err := l.GetReadingSessions(path); err != nil || len(sessions) != 0 {
+		t.Errorf("sessions = %+v, %v, want none", sessions, err)
+	}
+}
@@ -0,0 +1,464 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"switcher/foliate"
+	"switcher/koreader"
+	"switcher/okular"
+	"switcher/pdf"
+	"switcher/sioyek"
+	"switcher/zathura"
+	"sync"
+)
+
+// ReadingProgress is what a reader knows about a book it opened.
+type ReadingProgress struct {
+	Page int
+	// Pages is the length in the reader's own units, 0 when unknown
+	Pages int
+	// LastRead is when the reader last saved the position, in unix seconds
+	LastRead int64
+	// Percent is how far the book has been read, from 0 to 100, for readers
+	// that say so
+	Percent    float64
+	Finished   bool
+	Highlights int
+	// Location is the position in the reader's own terms, e.g. an EPUB CFI,
+	// empty when the page is all it has
+	Location string
+	// Title and Author are the reader's own metadata, empty when it has none
+	Title  string
+	Author string
+}
+
+// ProgressSource is a reader whose reading positions show up in the library.
+type ProgressSource interface {
+	Name() string
+	// KnownBooks returns the progress of every book the reader knows, by
+	// path. books are the paths of the library, for readers that keep their
+	// data next to each book; others may return books outside of it.
+	KnownBooks(books []string) (map[string]ReadingProgress, error)
+}
+
+// WatchedSource is implemented by sources that can tell where they keep
+// their data. The book index compares files by size and mtime and watches
+// dirs with inotify; without it, the index is rebuilt on every search.
+type WatchedSource interface {
+	WatchPaths() (files, dirs []string)
+}
+
+// ErrSourceNotFound is returned when the data of a reader does not exist,
+// usually because it is not installed. NewProgressSources skips the source.
+var ErrSourceNotFound = errors.New("reader data not found")
+
+// SourceFactory opens a progress source. path overrides where the reader
+// keeps its data; "" means its default place.
+type SourceFactory func(path string) (ProgressSource, error)
+
+var (
+	sourcesMu       sync.Mutex
+	sourceFactories = map[string]SourceFactory{
+		"foliate":  newFoliateSource,
+		"koreader": newKOReaderSource,
+		"okular":   newOkularSource,
+		"sioyek":   newSioyekSource,
+		"zathura":  newZathuraSource,
+	}
+)
+
+// RegisterProgressSource makes a reader available to NewProgressSource and
+// NewProgressSources under name, replacing any source of that name.
+func RegisterProgressSource(name string, factory SourceFactory) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	sourceFactories[name] = factory
+}
+
+// ProgressSourceNames returns the names of the registered sources, sorted.
+func ProgressSourceNames() []string {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	names := make([]string, 0, len(sourceFactories))
+	for name := range sourceFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProgressSource opens a single source by name.
+func NewProgressSource(name, path string) (ProgressSource, error) {
+	sourcesMu.Lock()
+	factory, ok := sourceFactories[name]
+	sourcesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown progress source %q", name)
+	}
+	return factory(path)
+}
+
+// SourceConfig configures a source for NewProgressSources.
+type SourceConfig struct {
+	Disabled bool
+	Path     string
+}
+
+// NewProgressSources opens every registered source that configs does not
+// disable, in name order. Sources that are not installed or fail to open
+// are skipped; configuring an unknown source is an error.
+func NewProgressSources(configs map[string]SourceConfig) ([]ProgressSource, error) {
+	names := ProgressSourceNames()
+	for name := range configs {
+		if i := sort.SearchStrings(names, name); i == len(names) || names[i] != name {
+			return nil, fmt.Errorf("unknown progress source %q", name)
+		}
+	}
+
+	var sources []ProgressSource
+	for _, name := range names {
+		config := configs[name]
+		if config.Disabled {
+			continue
+		}
+		source, err := NewProgressSource(name, config.Path)
+		if errors.Is(err, ErrSourceNotFound) {
+			log.Printf("Skipping %s progress: %v", name, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("Error opening %s progress, continuing without it: %v", name, err)
+			continue
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// sourceProgress is what one source knows, by path.
+type sourceProgress struct {
+	source string
+	books  map[string]ReadingProgress
+}
+
+// applyProgress merges what the sources know about book: the position
+// saved last wins, and metadata of the sources overrides the book's.
+func applyProgress(book *Book, known []sourceProgress) {
+	found := false
+	for _, source := range known {
+		progress, ok := source.books[book.FilePath]
+		if !ok {
+			continue
+		}
+		if progress.Title != "" {
+			book.Title = progress.Title
+		}
+		if progress.Author != "" {
+			book.Author = progress.Author
+		}
+		if found && progress.LastRead < book.LastRead {
+			continue
+		}
+		found = true
+		book.Page = progress.Page
+		if progress.Pages > 0 {
+			book.Pages = progress.Pages
+		}
+		book.LastRead = progress.LastRead
+		book.Percent = progress.Percent
+		book.Finished = progress.Finished
+		book.Highlights = progress.Highlights
+		book.Location = progress.Location
+		book.ProgressSource = source.source
+	}
+}
+
+func sourceExists(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrSourceNotFound, path)
+	}
+	return nil
+}
+
+// zathuraSource reads the positions zathura keeps in its bookmarks database.
+type zathuraSource struct {
+	*zathura.Zathura
+}
+
+func newZathuraSource(path string) (ProgressSource, error) {
+	if path == "" {
+		var err error
+		if path, err = zathura.GetDatabasePath(); err != nil {
+			return nil, err
+		}
+	}
+	if err := sourceExists(path); err != nil {
+		return nil, err
+	}
+	zat, err := zathura.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return zathuraSource{zat}, nil
+}
+
+func (s zathuraSource) Name() string {
+	return "zathura"
+}
+
+func (s zathuraSource) KnownBooks([]string) (map[string]ReadingProgress, error) {
+	books, err := s.GetAllKnownBooks()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]ReadingProgress, len(books))
+	for path, book := range books {
+		// zathura counts pages from 0
+		known[path] = ReadingProgress{Page: book.Page + 1, LastRead: book.LastRead}
+	}
+	return known, nil
+}
+
+func (s zathuraSource) WatchPaths() (files, dirs []string) {
+	return []string{s.Path, s.Path + "-wal"}, nil
+}
+
+func (s zathuraSource) Close() error {
+	return s.DB.Close()
+}
+
+// foliateSource reads the positions Foliate keeps in a json file per book.
+type foliateSource struct {
+	*foliate.Foliate
+}
+
+func newFoliateSource(path string) (ProgressSource, error) {
+	if path == "" {
+		var err error
+		if path, err = foliate.GetDataPath(); err != nil {
+			return nil, err
+		}
+	}
+	if err := sourceExists(path); err != nil {
+		return nil, err
+	}
+	return foliateSource{&foliate.Foliate{DataPath: path}}, nil
+}
+
+func (s foliateSource) Name() string {
+	return "foliate"
+}
+
+func (s foliateSource) KnownBooks([]string) (map[string]ReadingProgress, error) {
+	books, err := s.GetAllKnownBooks()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]ReadingProgress, len(books))
+	for path, book := range books {
+		known[path] = ReadingProgress{
+			Page:     book.Page,
+			Pages:    book.Pages,
+			LastRead: book.LastRead,
+			Location: book.Location,
+			Title:    book.Title,
+			Author:   book.Author,
+		}
+	}
+	return known, nil
+}
+
+// WatchPaths are the list of books and the directory of per-book files.
+func (s foliateSource) WatchPaths() (files, dirs []string) {
+	return []string{filepath.Join(s.DataPath, "library", "uri-store.json")}, []string{s.DataPath}
+}
+
+// koreaderSource reads the metadata.<ext>.lua files KOReader keeps in a .sdr
+// directory next to each book, or below its docsettings folder when dir is
+// set. They are found by the library's watcher like the books themselves.
+type koreaderSource struct {
+	dir string
+}
+
+func newKOReaderSource(path string) (ProgressSource, error) {
+	if path != "" {
+		if err := sourceExists(path); err != nil {
+			return nil, err
+		}
+	}
+	return koreaderSource{dir: path}, nil
+}
+
+func (s koreaderSource) Name() string {
+	return "koreader"
+}
+
+func (s koreaderSource) KnownBooks(books []string) (map[string]ReadingProgress, error) {
+	known := make(map[string]ReadingProgress)
+	for _, book := range books {
+		path := koreader.SidecarPath(book, "")
+		if _, err := os.Stat(path); err != nil && s.dir != "" {
+			path = koreader.SidecarPath(book, s.dir)
+		}
+		sidecar, err := koreader.ReadSidecar(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error reading KOReader progress of %s: %v", book, err)
+			continue
+		}
+
+		progress := ReadingProgress{
+			Page:       sidecar.Page,
+			Pages:      sidecar.Pages,
+			LastRead:   sidecar.LastRead,
+			Percent:    sidecar.Percent,
+			Finished:   sidecar.Status == "complete",
+			Highlights: sidecar.Highlights,
+			Title:      sidecar.Title,
+		}
+		if len(sidecar.Authors) > 0 {
+			progress.Author = strings.Join(sidecar.Authors, ", ")
+		}
+		known[book] = progress
+	}
+	return known, nil
+}
+
+func (s koreaderSource) WatchPaths() (files, dirs []string) {
+	if s.dir != "" {
+		dirs = []string{s.dir}
+	}
+	return nil, dirs
+}
+
+// sioyekSource reads the positions sioyek keeps in its databases. sioyek
+// saves how far down the document it was, so the page is found from the
+// page heights of the file, which are kept until it changes.
+type sioyekSource struct {
+	*sioyek.Sioyek
+
+	mu      sync.Mutex
+	heights map[string]pageHeights
+}
+
+type pageHeights struct {
+	stamp   fileStamp
+	heights []float64
+}
+
+func newSioyekSource(path string) (ProgressSource, error) {
+	if path == "" {
+		var err error
+		if path, err = sioyek.GetDataPath(); err != nil {
+			return nil, err
+		}
+	}
+	if err := sourceExists(path); err != nil {
+		return nil, err
+	}
+	s, err := sioyek.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &sioyekSource{Sioyek: s, heights: make(map[string]pageHeights)}, nil
+}
+
+func (s *sioyekSource) Name() string {
+	return "sioyek"
+}
+
+func (s *sioyekSource) KnownBooks([]string) (map[string]ReadingProgress, error) {
+	books, err := s.GetAllKnownBooks()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]ReadingProgress, len(books))
+	for path, book := range books {
+		progress := ReadingProgress{LastRead: book.LastRead, Highlights: book.Highlights}
+		if heights := s.pageHeights(path); len(heights) > 0 {
+			progress.Page = sioyek.PageOf(book.OffsetY, heights)
+			progress.Pages = len(heights)
+		}
+		known[path] = progress
+	}
+	return known, nil
+}
+
+// pageHeights returns the page heights of a PDF, nil for other formats as
+// sioyek lays them out itself.
+func (s *sioyekSource) pageHeights(path string) []float64 {
+	if bookFormat(path) != "pdf" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	stamp := fileStamp{size: info.Size(), mtime: info.ModTime()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.heights[path]; ok && cached.stamp == stamp {
+		return cached.heights
+	}
+	heights, err := pdf.PageHeights(path)
+	if err != nil {
+		log.Printf("Error reading the pages of %s: %v", path, err)
+	}
+	s.heights[path] = pageHeights{stamp: stamp, heights: heights}
+	return heights
+}
+
+func (s *sioyekSource) WatchPaths() (files, dirs []string) {
+	for _, path := range []string{s.LocalPath, s.SharedPath} {
+		if path != "" {
+			files = append(files, path, path+"-wal")
+		}
+	}
+	return files, nil
+}
+
+// okularSource reads the positions Okular keeps in its docdata directory.
+type okularSource struct {
+	*okular.Okular
+}
+
+func newOkularSource(path string) (ProgressSource, error) {
+	if path == "" {
+		var err error
+		if path, err = okular.GetDataPath(); err != nil {
+			return nil, err
+		}
+	}
+	if err := sourceExists(path); err != nil {
+		return nil, err
+	}
+	return okularSource{&okular.Okular{DataPath: path}}, nil
+}
+
+func (s okularSource) Name() string {
+	return "okular"
+}
+
+func (s okularSource) KnownBooks([]string) (map[string]ReadingProgress, error) {
+	books, err := s.GetAllKnownBooks()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]ReadingProgress, len(books))
+	for path, book := range books {
+		known[path] = ReadingProgress{Page: book.Page, LastRead: book.LastRead, Highlights: book.Annotations}
+	}
+	return known, nil
+}
+
+func (s okularSource) WatchPaths() (files, dirs []string) {
+	return nil, []string{s.DataPath}
+}
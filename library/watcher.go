@@ -0,0 +1,457 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchEvent describes the library changes made by the watcher in one go.
+type WatchEvent struct {
+	Added   int      `json:"added"`
+	Updated int      `json:"updated"`
+	Removed int      `json:"removed"`
+	Paths   []string `json:"paths,omitempty"`
+}
+
+func (e WatchEvent) empty() bool {
+	return e.Added == 0 && e.Updated == 0 && e.Removed == 0
+}
+
+// Watcher keeps the library in sync with the scan roots using inotify.
+// Events are debounced per file because sync clients write books in many
+// small chunks; a file is only read once it has been quiet for Debounce and
+// its size stopped changing.
+type Watcher struct {
+	Debounce time.Duration
+	// OnChange is called from the event loop and from rescans
+	OnChange func(WatchEvent)
+
+	library *Library
+	roots   []Root
+	ignore  map[string]*ignoreMatcher
+	fs      *fsnotify.Watcher
+	// dirs are the watched directories, only used by the event loop
+	dirs map[string]bool
+
+	mu      sync.Mutex
+	pending map[string]*pendingFile
+	rescans map[string]time.Time
+	// busy is set while a batch is read in the background
+	busy bool
+}
+
+type pendingFile struct {
+	lastEvent time.Time
+	size      int64
+}
+
+func (l *Library) NewWatcher(roots []Root) (*Watcher, error) {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("error creating watcher: %w", err)
+	}
+
+	w := &Watcher{
+		Debounce: 2 * time.Second,
+		library:  l,
+		ignore:   make(map[string]*ignoreMatcher),
+		fs:       fsWatcher,
+		dirs:     make(map[string]bool),
+		pending:  make(map[string]*pendingFile),
+		rescans:  make(map[string]time.Time),
+	}
+
+	for _, root := range roots {
+		root.Path = filepath.Clean(root.Path)
+		w.roots = append(w.roots, root)
+		w.ignore[root.Path] = l.ignoreMatcher(root)
+		if err := w.addTree(root.Path); err != nil {
+			log.Printf("Error watching %s: %v", root.Path, err)
+		}
+	}
+
+	return w, nil
+}
+
+// addTree watches dir and every directory below it that is not ignored.
+func (w *Watcher) addTree(dir string) error {
+	root, watched := w.rootOf(dir)
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories can vanish while a sync client is busy
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if watched && w.ignore[root.Path].Match(path, true) {
+			return filepath.SkipDir
+		}
+		if err := w.fs.Add(path); err != nil {
+			log.Printf("Error watching %s: %v", path, err)
+			return nil
+		}
+		w.dirs[path] = true
+		return nil
+	})
+}
+
+// rootOf returns the innermost watched root containing path.
+func (w *Watcher) rootOf(path string) (Root, bool) {
+	var found Root
+	ok := false
+	for _, root := range w.roots {
+		if root.contains(path) && (!ok || len(root.Path) > len(found.Path)) {
+			found = root
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// Run processes filesystem events until ctx is cancelled.
+func (w *Watcher) Run(ctx context.Context) error {
+	defer w.fs.Close()
+
+	tick := w.Debounce / 4
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.fs.Events:
+			if !ok {
+				return nil
+			}
+			w.handle(event)
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return nil
+			}
+			// Queue overflows lose events, fall back to a full rescan
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				for _, root := range w.roots {
+					w.scheduleRescan(root.Path)
+				}
+			}
+			log.Printf("Watcher error: %v", err)
+		case <-ticker.C:
+			w.flush(ctx)
+		}
+	}
+}
+
+func (w *Watcher) handle(event fsnotify.Event) {
+	path := event.Name
+	root, ok := w.rootOf(path)
+	if !ok {
+		return
+	}
+	ignore := w.ignore[root.Path]
+
+	// Any .ignore file can hide or reveal books anywhere below it
+	if filepath.Base(path) == ignoreFileName {
+		ignore.invalidate(filepath.Dir(path))
+		w.scheduleRescan(root.Path)
+		return
+	}
+
+	if ignore.Match(path, false) {
+		return
+	}
+
+	// KOReader keeps reading progress in a .sdr directory next to the book
+	if filepath.Ext(path) == ".sdr" || filepath.Ext(filepath.Dir(path)) == ".sdr" {
+		w.library.invalidateIndex()
+		if !event.Has(fsnotify.Create) {
+			return
+		}
+	}
+
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			if ignore.Match(path, true) {
+				return
+			}
+			// Files may have landed before the watch was added, and a
+			// directory moved in brings its books along
+			if err := w.addTree(path); err != nil {
+				log.Printf("Error watching %s: %v", path, err)
+			}
+			w.scheduleRescan(root.Path)
+			return
+		}
+	}
+
+	if root.accepts(bookFormat(path)) {
+		w.mu.Lock()
+		if p, ok := w.pending[path]; ok {
+			p.lastEvent = time.Now()
+		} else {
+			w.pending[path] = &pendingFile{lastEvent: time.Now(), size: -1}
+		}
+		w.mu.Unlock()
+		return
+	}
+
+	// A directory was removed or renamed away, the books inside went with
+	// it. It can't be stat'ed anymore, so only watched ones are known.
+	if (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) && w.dirs[path] {
+		w.forgetTree(path)
+		w.scheduleRescan(root.Path)
+	}
+}
+
+// forgetTree drops dir and the directories below it from the watched ones;
+// inotify removes their watches on its own.
+func (w *Watcher) forgetTree(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for path := range w.dirs {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			delete(w.dirs, path)
+		}
+	}
+}
+
+func (w *Watcher) scheduleRescan(root string) {
+	w.mu.Lock()
+	w.rescans[root] = time.Now()
+	w.mu.Unlock()
+}
+
+// flush hands the files and roots that have been quiet for Debounce to a
+// background batch, one at a time, so events keep being read meanwhile.
+func (w *Watcher) flush(ctx context.Context) {
+	now := time.Now()
+	var ready, batch, rescans []string
+	missing := make(map[string]bool)
+
+	w.mu.Lock()
+	if w.busy {
+		w.mu.Unlock()
+		return
+	}
+	for path, p := range w.pending {
+		if now.Sub(p.lastEvent) < w.Debounce {
+			continue
+		}
+		// Wait until the size settles, partial downloads keep growing
+		info, err := os.Stat(path)
+		if err == nil && info.Size() != p.size {
+			p.size = info.Size()
+			p.lastEvent = now
+			continue
+		}
+		missing[path] = os.IsNotExist(err)
+		ready = append(ready, path)
+	}
+	// A renamed book is a missing file and a new one that has yet to
+	// settle; missing files wait for it to pair them up
+	settling := len(ready) < len(w.pending)
+	for _, path := range ready {
+		if settling && missing[path] {
+			continue
+		}
+		delete(w.pending, path)
+		batch = append(batch, path)
+	}
+	for root, last := range w.rescans {
+		if now.Sub(last) >= w.Debounce {
+			delete(w.rescans, root)
+			rescans = append(rescans, root)
+		}
+	}
+	w.busy = len(batch) > 0 || len(rescans) > 0
+	w.mu.Unlock()
+
+	if len(batch) > 0 || len(rescans) > 0 {
+		go w.process(ctx, batch, rescans)
+	}
+}
+
+// process reads changed files and rescans roots, waiting for a scan that is
+// already running.
+func (w *Watcher) process(ctx context.Context, paths, roots []string) {
+	defer func() {
+		w.mu.Lock()
+		w.busy = false
+		w.mu.Unlock()
+	}()
+
+	l := w.library
+	l.scanMu.Lock()
+	var event WatchEvent
+	w.updateFiles(paths, &event)
+	for _, path := range roots {
+		root, _ := w.rootOf(path)
+		report, err := l.scanRoot(ctx, root, nil)
+		if err != nil {
+			log.Printf("Error rescanning %s: %v", path, err)
+		}
+		event.Added += report.Added
+		event.Updated += report.Updated
+		event.Removed += report.Removed
+	}
+	l.scanMu.Unlock()
+	w.notify(event)
+}
+
+func (w *Watcher) notify(event WatchEvent) {
+	if !event.empty() && w.OnChange != nil {
+		w.OnChange(event)
+	}
+}
+
+// updateFiles brings the books at paths up to date. A missing book and a
+// new file of the same format and identity in its root were renamed, and
+// keep the book's row and reading history like the scan's moves do.
+func (w *Watcher) updateFiles(paths []string, event *WatchEvent) {
+	l := w.library
+	gone := make(map[fileState]string)
+	for _, path := range paths {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			continue
+		}
+		if state, ok, err := l.storedState(path); err == nil && ok && state.Inode != 0 {
+			gone[state] = path
+		}
+	}
+
+	moved := make(map[string]bool)
+	if len(gone) > 0 {
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			oldPath, ok := gone[statOf(info)]
+			if !ok || bookFormat(oldPath) != bookFormat(path) {
+				continue
+			}
+			if w.rootPath(oldPath) != w.rootPath(path) {
+				continue
+			}
+			if err := w.moveFile(oldPath, path); err != nil {
+				log.Printf("Error moving book %s to %s: %v", oldPath, path, err)
+				continue
+			}
+			log.Printf("Book moved: %s -> %s", oldPath, path)
+			delete(gone, statOf(info))
+			moved[oldPath], moved[path] = true, true
+			event.Updated++
+			event.Paths = append(event.Paths, path)
+		}
+	}
+
+	for _, path := range paths {
+		if !moved[path] {
+			w.updateFile(path, event)
+		}
+	}
+}
+
+func (w *Watcher) rootPath(path string) string {
+	root, _ := w.rootOf(path)
+	return root.Path
+}
+
+func (w *Watcher) moveFile(oldPath, path string) error {
+	l := w.library
+	tx, err := l.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := moveBook(tx, oldPath, path); err != nil {
+		return err
+	}
+	err = tx.Commit()
+	l.invalidateIndex()
+	return err
+}
+
+func (w *Watcher) updateFile(path string, event *WatchEvent) {
+	l := w.library
+	root, _ := w.rootOf(path)
+	known, ok, err := l.storedState(path)
+	if err != nil {
+		log.Printf("Error reading state of %s: %v", path, err)
+		return
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if !ok {
+			return
+		}
+		// The file went away with its drive
+		if online, _ := root.online(); !online {
+			return
+		}
+		if err := l.RemoveBook(path); err != nil {
+			log.Printf("Error removing book %s: %v", path, err)
+			return
+		}
+		log.Printf("Removed missing book: %s", path)
+		event.Removed++
+		event.Paths = append(event.Paths, path)
+		return
+	}
+	if err != nil || info.IsDir() {
+		return
+	}
+
+	state := statOf(info)
+	if ok && known == state {
+		return
+	}
+	book := l.extractBook(path, state)
+	book.Root = root.Name()
+	err = l.saveBook(l.DB, book)
+	l.invalidateIndex()
+	if err != nil {
+		log.Printf("Error saving book %s: %v", path, err)
+		return
+	}
+	if ok {
+		event.Updated++
+	} else {
+		event.Added++
+	}
+	event.Paths = append(event.Paths, path)
+}
+
+// storedState returns the recorded state of a single file.
+func (l *Library) storedState(filePath string) (fileState, bool, error) {
+	var state fileState
+	var inode int64
+	err := l.DB.QueryRow("SELECT size, mtime, inode FROM books WHERE filepath = ?", filePath).
+		Scan(&state.Size, &state.Mtime, &inode)
+	if err == sql.ErrNoRows {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	state.Inode = uint64(inode)
+	return state, true, nil
+}
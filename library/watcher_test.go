@@ -0,0 +1,133 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startWatcher runs a watcher over the roots of l with a short debounce and
+// returns the events it reports.
+func startWatcher(t *testing.T, l *Library) func() []WatchEvent {
+	t.Helper()
+	w, err := l.NewWatcher(l.Roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Debounce = 50 * time.Millisecond
+	var mu sync.Mutex
+	var events []WatchEvent
+	w.OnChange = func(event WatchEvent) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go w.Run(ctx)
+	return func() []WatchEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]WatchEvent(nil), events...)
+	}
+}
+
+// waitFor polls cond until it holds or a few seconds passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func bookPaths(t *testing.T, l *Library) map[string]bool {
+	t.Helper()
+	books, err := l.GetAllBooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make(map[string]bool)
+	for _, book := range books {
+		paths[book.FilePath] = true
+	}
+	return paths
+}
+
+func TestWatcherAddsAndRemoves(t *testing.T) {
+	l, root := newTestLibrary(t)
+	events := startWatcher(t, l)
+
+	path := writeBook(t, root, "Stanisław Lem - Solaris.epub")
+	waitFor(t, "the new book", func() bool { return bookPaths(t, l)[path] })
+
+	// Files that are not books change nothing
+	notes := filepath.Join(root, "notes.txt")
+	os.WriteFile(notes, []byte("x"), 0o644)
+	os.Remove(notes)
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the removal", func() bool { return !bookPaths(t, l)[path] })
+
+	var added, removed int
+	for _, event := range events() {
+		added += event.Added
+		removed += event.Removed
+	}
+	if added != 1 || removed != 1 {
+		t.Errorf("events = %+v, want one book added and removed", events())
+	}
+}
+
+func TestWatcherRenameKeepsHistory(t *testing.T) {
+	l, root := newTestLibrary(t)
+	oldPath := writeBook(t, root, "old/Lem - Solaris.epub")
+	if _, err := l.Scan(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.RecordOpen(oldPath); err != nil {
+		t.Fatal(err)
+	}
+	events := startWatcher(t, l)
+
+	newPath := filepath.Join(root, "Lem - Solaris (1961).epub")
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the rename", func() bool { paths := bookPaths(t, l); return paths[newPath] && !paths[oldPath] })
+
+	var opens int
+	if err := l.DB.QueryRow(`SELECT count(*) FROM open_events WHERE filepath = ?`, newPath).Scan(&opens); err != nil {
+		t.Fatal(err)
+	}
+	if opens != 1 {
+		t.Errorf("%d opens moved with the book, want 1", opens)
+	}
+	for _, event := range events() {
+		if event.Added != 0 || event.Removed != 0 {
+			t.Errorf("rename reported as %+v, want an update", event)
+		}
+	}
+}
+
+func TestWatcherRemovedDirectory(t *testing.T) {
+	l, root := newTestLibrary(t)
+	path := writeBook(t, root, "series/Lem - Solaris.epub")
+	if _, err := l.Scan(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	startWatcher(t, l)
+
+	if err := os.RemoveAll(filepath.Join(root, "series")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the removal", func() bool { return !bookPaths(t, l)[path] })
+}
@@ -97,6 +97,14 @@ func main() {
 		runDoctorCommand()
 		return
 	}
+	if len(os.Args) > 1 && os.Args[1] == "db" {
+		runDbCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "stats" {
+		runStatsCommand(os.Args[2:])
+		return
+	}
 
 	checkAlreadyRuns()
 	// Create an instance of the app structure
@@ -112,6 +120,7 @@ func main() {
 		},
 		BackgroundColour: &options.RGBA{R: 245, G: 247, B: 250, A: 1},
 		OnStartup:        app.startup,
+		OnShutdown:       app.Shutdown,
 		Bind: []any{
 			app,
 		},
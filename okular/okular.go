@@ -0,0 +1,113 @@
+package okular
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func GetDataPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".local/share/okular/docdata"), nil
+}
+
+// Okular reads the docdata directory, where Okular keeps an xml file per
+// document named after its size and file name.
+type Okular struct {
+	DataPath string
+}
+
+type BookInfo struct {
+	Filename string `json:"filename"`
+	// Page is the 1-based page of the viewport Okular was closed at
+	Page        int `json:"page"`
+	Annotations int `json:"annotations,omitempty"`
+	// LastRead is when Okular last saved the file, in unix seconds
+	LastRead int64 `json:"lastRead,omitempty"`
+}
+
+// documentInfo is the part of a docdata file read here.
+type documentInfo struct {
+	URL     string `xml:"url,attr"`
+	Current struct {
+		Viewport string `xml:"viewport,attr"`
+	} `xml:"generalInfo>history>current"`
+	Pages []struct {
+		Annotations []struct{} `xml:"annotationList>annotation"`
+	} `xml:"pageList>page"`
+}
+
+func (o *Okular) GetAllKnownBooks() (map[string]BookInfo, error) {
+	files, err := filepath.Glob(filepath.Join(o.DataPath, "*.xml"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing docdata: %w", err)
+	}
+
+	books := make(map[string]BookInfo)
+	for _, file := range files {
+		book, err := readDocData(file)
+		if err != nil {
+			log.Printf("Error reading %s: %v", file, err)
+			continue
+		}
+		if book.Filename == "" {
+			continue
+		}
+		if _, err := os.Stat(book.Filename); os.IsNotExist(err) {
+			log.Printf("File does not exist: %s", book.Filename)
+			continue
+		}
+		if known, ok := books[book.Filename]; ok && known.LastRead > book.LastRead {
+			continue
+		}
+		books[book.Filename] = book
+	}
+	return books, nil
+}
+
+func readDocData(file string) (BookInfo, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return BookInfo{}, err
+	}
+	var info documentInfo
+	if err := xml.Unmarshal(data, &info); err != nil {
+		return BookInfo{}, err
+	}
+
+	book := BookInfo{Filename: filePath(info.URL)}
+	if stat, err := os.Stat(file); err == nil {
+		book.LastRead = stat.ModTime().Unix()
+	}
+	// The viewport is the 0-based page followed by the position on it,
+	// e.g. "12;C2:0.5:0.3:1"
+	page, _, _ := strings.Cut(info.Current.Viewport, ";")
+	if n, err := strconv.Atoi(page); err == nil && n >= 0 {
+		book.Page = n + 1
+	}
+	for _, page := range info.Pages {
+		book.Annotations += len(page.Annotations)
+	}
+	return book, nil
+}
+
+// filePath returns the local path of url, which Okular writes as a plain
+// path or a file:// URL. Remote documents have none.
+func filePath(rawURL string) string {
+	if filepath.IsAbs(rawURL) {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return u.Path
+}
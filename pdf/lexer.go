@@ -0,0 +1,369 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// PDF objects are represented with plain Go values:
+//
+//	null        nil
+//	boolean     bool
+//	integer     int64
+//	real        float64
+//	string      string (raw bytes, see decodeText)
+//	name        name
+//	array       array
+//	dictionary  dict
+//	reference   ref
+//	stream      *stream
+type name string
+
+type keyword string
+
+type array []any
+
+type dict map[name]any
+
+type ref struct {
+	num int
+	gen int
+}
+
+type stream struct {
+	dict dict
+	data []byte
+}
+
+type delimiter string
+
+type lexer struct {
+	r      *bufio.Reader
+	tokens []any
+}
+
+func newLexer(r io.Reader) *lexer {
+	return &lexer{r: bufio.NewReader(r)}
+}
+
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isDelimiter(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+func (lx *lexer) unread(token any) {
+	lx.tokens = append(lx.tokens, token)
+}
+
+func (lx *lexer) skipSpace() (byte, error) {
+	for {
+		c, err := lx.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c == '%' {
+			// Comments run to the end of the line
+			for c != '\r' && c != '\n' {
+				if c, err = lx.r.ReadByte(); err != nil {
+					return 0, err
+				}
+			}
+			continue
+		}
+		if !isWhitespace(c) {
+			return c, nil
+		}
+	}
+}
+
+func (lx *lexer) token() (any, error) {
+	if n := len(lx.tokens); n > 0 {
+		token := lx.tokens[n-1]
+		lx.tokens = lx.tokens[:n-1]
+		return token, nil
+	}
+
+	c, err := lx.skipSpace()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c {
+	case '[', ']', '{', '}':
+		return delimiter(c), nil
+	case '<':
+		next, err := lx.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if next == '<' {
+			return delimiter("<<"), nil
+		}
+		lx.r.UnreadByte()
+		return lx.hexString()
+	case '>':
+		next, err := lx.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if next == '>' {
+			return delimiter(">>"), nil
+		}
+		lx.r.UnreadByte()
+		return nil, fmt.Errorf("unexpected '>'")
+	case ')':
+		return nil, fmt.Errorf("unexpected ')'")
+	case '(':
+		return lx.literalString()
+	case '/':
+		return lx.name()
+	}
+
+	lx.r.UnreadByte()
+	var word []byte
+	for {
+		c, err := lx.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isWhitespace(c) || isDelimiter(c) {
+			lx.r.UnreadByte()
+			break
+		}
+		word = append(word, c)
+	}
+	if len(word) == 0 {
+		// Not a word but a delimiter without a token of its own; it was
+		// put back, so skip it for the next call to make progress
+		lx.r.ReadByte()
+		return nil, fmt.Errorf("unexpected %q", c)
+	}
+	return parseWord(string(word)), nil
+}
+
+func parseWord(word string) any {
+	switch word {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if i, err := strconv.ParseInt(word, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil {
+		return f
+	}
+	return keyword(word)
+}
+
+func (lx *lexer) name() (any, error) {
+	var b []byte
+	for {
+		c, err := lx.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isWhitespace(c) || isDelimiter(c) {
+			lx.r.UnreadByte()
+			break
+		}
+		if c == '#' {
+			hex := make([]byte, 2)
+			if _, err := io.ReadFull(lx.r, hex); err != nil {
+				return nil, err
+			}
+			v, err := strconv.ParseUint(string(hex), 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid name escape #%s", hex)
+			}
+			c = byte(v)
+		}
+		b = append(b, c)
+	}
+	return name(b), nil
+}
+
+func (lx *lexer) literalString() (any, error) {
+	var b []byte
+	depth := 1
+	for {
+		c, err := lx.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(b), nil
+			}
+		case '\\':
+			c, err = lx.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// Line continuation
+				if next, err := lx.r.ReadByte(); err == nil && next != '\n' {
+					lx.r.UnreadByte()
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for i := 0; i < 2; i++ {
+						next, err := lx.r.ReadByte()
+						if err != nil {
+							return nil, err
+						}
+						if next < '0' || next > '7' {
+							lx.r.UnreadByte()
+							break
+						}
+						v = v*8 + int(next-'0')
+					}
+					c = byte(v)
+				}
+			}
+		}
+		b = append(b, c)
+	}
+}
+
+func (lx *lexer) hexString() (any, error) {
+	var digits []byte
+	for {
+		c, err := lx.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == '>' {
+			break
+		}
+		if isWhitespace(c) {
+			continue
+		}
+		digits = append(digits, c)
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, len(digits)/2)
+	for i := range b {
+		v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex string")
+		}
+		b[i] = byte(v)
+	}
+	return string(b), nil
+}
+
+var errUnexpectedToken = errors.New("unexpected token")
+
+// object reads a complete object, combining "num gen R" into a reference.
+func (lx *lexer) object() (any, error) {
+	token, err := lx.token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case delimiter:
+		switch t {
+		case "[":
+			var arr array
+			for {
+				next, err := lx.token()
+				if err != nil {
+					return nil, err
+				}
+				if next == delimiter("]") {
+					return arr, nil
+				}
+				lx.unread(next)
+				obj, err := lx.object()
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, obj)
+			}
+		case "<<":
+			d := make(dict)
+			for {
+				next, err := lx.token()
+				if err != nil {
+					return nil, err
+				}
+				if next == delimiter(">>") {
+					return d, nil
+				}
+				key, ok := next.(name)
+				if !ok {
+					return nil, fmt.Errorf("%w: dictionary key %v", errUnexpectedToken, next)
+				}
+				value, err := lx.object()
+				if err != nil {
+					return nil, err
+				}
+				d[key] = value
+			}
+		}
+		return nil, fmt.Errorf("%w: %v", errUnexpectedToken, t)
+	case int64:
+		// Look ahead for "gen R"
+		gen, err := lx.token()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if g, ok := gen.(int64); ok {
+			r, err := lx.token()
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			if err == nil && r == keyword("R") {
+				return ref{num: int(t), gen: int(g)}, nil
+			}
+			if err == nil {
+				lx.unread(r)
+			}
+		}
+		lx.unread(gen)
+		return t, nil
+	}
+	return token, nil
+}
@@ -0,0 +1,140 @@
+package pdf
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseObject reads one object from input, failing the test instead of
+// hanging on input the lexer does not move past.
+func parseObject(t *testing.T, input string) (any, error) {
+	t.Helper()
+	type result struct {
+		obj any
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		obj, err := newLexer(strings.NewReader(input)).object()
+		done <- result{obj, err}
+	}()
+	select {
+	case r := <-done:
+		return r.obj, r.err
+	case <-time.After(2 * time.Second):
+		t.Fatalf("object(%q) did not return", input)
+		return nil, nil
+	}
+}
+
+func TestObject(t *testing.T) {
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{"42", int64(42)},
+		{"-1.5", -1.5},
+		{"true", true},
+		{"null", nil},
+		{"/Title", name("Title")},
+		{"/A#20B", name("A B")},
+		{"(Hello (nested) \\(world\\)\\n)", "Hello (nested) (world)\n"},
+		{`(\101\102C)`, "ABC"},
+		{"<48656C6C6F>", "Hello"},
+		{"<48 65 6c\n6c 6f>", "Hello"},
+		{"<486>", "H`"},
+		{"<>", ""},
+		{"[1 2 0 R /Name (s)]", array{int64(1), ref{num: 2, gen: 0}, name("Name"), "s"}},
+		{"[]", array(nil)},
+		{"<< /Type /Catalog /Pages 3 0 R /Kids [1 2] >>", dict{
+			"Type":  name("Catalog"),
+			"Pages": ref{num: 3, gen: 0},
+			"Kids":  array{int64(1), int64(2)},
+		}},
+		{"<</A<</B<00FF>>>>>", dict{"A": dict{"B": "\x00\xff"}}},
+		{"% comment\n 7 % another\n", int64(7)},
+		{"1 0 obj", int64(1)},
+	}
+	for _, tt := range tests {
+		got, err := parseObject(t, tt.input)
+		if err != nil {
+			t.Errorf("object(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("object(%q) = %#v, want %#v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestObjectMalformed(t *testing.T) {
+	for _, input := range []string{
+		// Stray delimiters
+		"[ 1 ) 2 ]",
+		"[ ) ]",
+		")",
+		"[ 1 > 2 ]",
+		"> >",
+		"<< /A 1 ) >>",
+		"<< 1 2 >>",
+		"]",
+		// Truncated objects
+		"",
+		"[ 1 2",
+		"<< /A 1",
+		"<< /A",
+		"(unterminated",
+		"(\\",
+		"<48656C",
+		"<",
+		"/A#4",
+		// Invalid hex strings
+		"<4G>",
+	} {
+		if obj, err := parseObject(t, input); err == nil {
+			t.Errorf("object(%q) = %#v, want an error", input, obj)
+		}
+	}
+}
+
+// TestTokenProgress reads every token of malformed input; each call must
+// consume something so that the end is reached.
+func TestTokenProgress(t *testing.T) {
+	for _, input := range []string{"1 ) 2", ") ) )", "> >> <", "[ } { ]"} {
+		lx := newLexer(strings.NewReader(input))
+		for i := 0; ; i++ {
+			if i > len(input) {
+				t.Fatalf("tokens of %q do not end", input)
+			}
+			if _, err := lx.token(); err == io.EOF {
+				break
+			}
+		}
+	}
+}
+
+func TestApplyPredictor(t *testing.T) {
+	// Two rows of three bytes, with the Sub and Up filters
+	data := []byte{1, 1, 1, 1, 2, 1, 1, 1, 1}
+	got, err := applyPredictor(data, dict{"Predictor": int64(12), "Columns": int64(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 2, 3, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applyPredictor = %v, want %v", got, want)
+	}
+
+	for _, params := range []dict{
+		{"Predictor": int64(12), "Columns": int64(1 << 40)},
+		{"Predictor": int64(12), "Colors": int64(1 << 20)},
+		{"Predictor": int64(12), "BitsPerComponent": int64(64)},
+		{"Predictor": int64(12), "Columns": int64(8), "Colors": int64(4)},
+	} {
+		if _, err := applyPredictor(data, params); err == nil {
+			t.Errorf("applyPredictor with %v succeeded", params)
+		}
+	}
+}
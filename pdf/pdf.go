@@ -0,0 +1,790 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+type Metadata struct {
+	Title     string   `json:"title"`
+	Authors   []string `json:"authors,omitempty"`
+	Subject   string   `json:"subject,omitempty"`
+	Keywords  string   `json:"keywords,omitempty"`
+	Pages     int      `json:"pages"`
+	Encrypted bool     `json:"encrypted,omitempty"`
+}
+
+type xrefEntry struct {
+	compressed bool
+	offset     int64 // byte offset, or object stream number when compressed
+	index      int   // index inside the object stream
+}
+
+type document struct {
+	file    io.ReaderAt
+	size    int64
+	xref    map[int]xrefEntry
+	trailer dict
+	objects map[int]any
+	depth   int
+}
+
+// maxDepth bounds reference chains so that malformed files with cyclic
+// references cannot recurse forever.
+const maxDepth = 32
+
+func ReadMetadata(filePath string) (*Metadata, error) {
+	doc, err := openDocument(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer doc.close()
+	return doc.metadata()
+}
+
+// PageHeights returns the height of every page in points, as shown: the
+// crop box, or the width for pages rotated by a quarter turn.
+func PageHeights(filePath string) ([]float64, error) {
+	doc, err := openDocument(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer doc.close()
+
+	root, ok := doc.resolve(doc.trailer["Root"]).(dict)
+	if !ok {
+		return nil, fmt.Errorf("pdf has no document catalog")
+	}
+	pages, ok := doc.resolve(root["Pages"]).(dict)
+	if !ok {
+		return nil, fmt.Errorf("pdf has no page tree")
+	}
+	var heights []float64
+	doc.pageHeights(pages, pageAttributes{}, make(map[int]bool), 0, &heights)
+	return heights, nil
+}
+
+func openDocument(filePath string) (*document, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening pdf: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading pdf: %w", err)
+	}
+
+	doc := &document{
+		file:    file,
+		size:    info.Size(),
+		xref:    make(map[int]xrefEntry),
+		objects: make(map[int]any),
+	}
+	err = doc.loadXref()
+	if err == nil {
+		if _, ok := doc.resolve(doc.trailer["Root"]).(dict); !ok {
+			err = fmt.Errorf("document catalog not found at its xref offset")
+		}
+	}
+	if err != nil {
+		// Damaged or hand-edited files often have wrong offsets; rebuild
+		// the table by scanning for "n g obj" markers instead.
+		doc.xref = make(map[int]xrefEntry)
+		doc.objects = make(map[int]any)
+		doc.trailer = nil
+		if repairErr := doc.reconstructXref(); repairErr != nil {
+			file.Close()
+			return nil, fmt.Errorf("error reading cross-reference table: %w", err)
+		}
+	}
+	return doc, nil
+}
+
+func (d *document) close() error {
+	if closer, ok := d.file.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (d *document) metadata() (*Metadata, error) {
+	root, ok := d.resolve(d.trailer["Root"]).(dict)
+	if !ok {
+		return nil, fmt.Errorf("pdf has no document catalog")
+	}
+
+	metadata := &Metadata{Pages: d.pageCount(root["Pages"])}
+
+	if _, ok := d.trailer["Encrypt"]; ok {
+		// Strings are encrypted, only the structure can be trusted
+		metadata.Encrypted = true
+		return metadata, nil
+	}
+
+	if info, ok := d.resolve(d.trailer["Info"]).(dict); ok {
+		metadata.Title = d.text(info["Title"])
+		if author := d.text(info["Author"]); author != "" {
+			metadata.Authors = []string{author}
+		}
+		metadata.Subject = d.text(info["Subject"])
+		metadata.Keywords = d.text(info["Keywords"])
+	}
+
+	if s, ok := d.resolve(root["Metadata"]).(*stream); ok {
+		if data, err := d.decodeStream(s); err == nil {
+			title, creators := parseXMP(data)
+			if metadata.Title == "" {
+				metadata.Title = title
+			}
+			if len(metadata.Authors) == 0 {
+				metadata.Authors = creators
+			}
+		}
+	}
+
+	return metadata, nil
+}
+
+func (d *document) text(obj any) string {
+	s, ok := d.resolve(obj).(string)
+	if !ok {
+		return ""
+	}
+	return decodeText(s)
+}
+
+func (d *document) pageCount(obj any) int {
+	pages, ok := d.resolve(obj).(dict)
+	if !ok {
+		return 0
+	}
+	if count, ok := d.resolve(pages["Count"]).(int64); ok && count > 0 {
+		return int(count)
+	}
+	return d.countLeaves(pages, make(map[int]bool), 0)
+}
+
+// countLeaves walks the page tree when the root /Count is missing or broken.
+func (d *document) countLeaves(node dict, seen map[int]bool, depth int) int {
+	if node["Type"] == name("Page") {
+		return 1
+	}
+	kids, ok := d.resolve(node["Kids"]).(array)
+	if !ok || depth > maxDepth {
+		return 0
+	}
+	count := 0
+	for _, kid := range kids {
+		if r, ok := kid.(ref); ok {
+			if seen[r.num] {
+				continue
+			}
+			seen[r.num] = true
+		}
+		if child, ok := d.resolve(kid).(dict); ok {
+			count += d.countLeaves(child, seen, depth+1)
+		}
+	}
+	return count
+}
+
+// pageAttributes are the page tree entries inherited by the pages below.
+type pageAttributes struct {
+	mediaBox array
+	cropBox  array
+	rotate   int64
+}
+
+func (d *document) pageHeights(node dict, inherited pageAttributes, seen map[int]bool, depth int, heights *[]float64) {
+	attrs := inherited
+	if box, ok := d.resolve(node["MediaBox"]).(array); ok {
+		attrs.mediaBox = box
+	}
+	if box, ok := d.resolve(node["CropBox"]).(array); ok {
+		attrs.cropBox = box
+	}
+	if rotate, ok := d.resolve(node["Rotate"]).(int64); ok {
+		attrs.rotate = rotate
+	}
+
+	if node["Type"] == name("Page") {
+		box := attrs.cropBox
+		if box == nil {
+			box = attrs.mediaBox
+		}
+		// US Letter when the file does not say
+		width, height := 612.0, 792.0
+		if len(box) == 4 {
+			width = math.Abs(d.number(box[2]) - d.number(box[0]))
+			height = math.Abs(d.number(box[3]) - d.number(box[1]))
+		}
+		if (attrs.rotate/90)%2 != 0 {
+			height = width
+		}
+		*heights = append(*heights, height)
+		return
+	}
+
+	kids, ok := d.resolve(node["Kids"]).(array)
+	if !ok || depth > maxDepth {
+		return
+	}
+	for _, kid := range kids {
+		if r, ok := kid.(ref); ok {
+			if seen[r.num] {
+				continue
+			}
+			seen[r.num] = true
+		}
+		if child, ok := d.resolve(kid).(dict); ok {
+			d.pageHeights(child, attrs, seen, depth+1, heights)
+		}
+	}
+}
+
+func (d *document) number(obj any) float64 {
+	switch n := d.resolve(obj).(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+func (d *document) readAt(offset int64) *lexer {
+	return newLexer(io.NewSectionReader(d.file, offset, d.size-offset))
+}
+
+func (d *document) findStartXref() (int64, error) {
+	tailSize := int64(2048)
+	if tailSize > d.size {
+		tailSize = d.size
+	}
+	tail := make([]byte, tailSize)
+	if _, err := d.file.ReadAt(tail, d.size-tailSize); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	i := bytes.LastIndex(tail, []byte("startxref"))
+	if i < 0 {
+		return 0, fmt.Errorf("startxref not found")
+	}
+	lx := newLexer(bytes.NewReader(tail[i+len("startxref"):]))
+	token, err := lx.token()
+	if err != nil {
+		return 0, err
+	}
+	offset, ok := token.(int64)
+	if !ok || offset < 0 || offset >= d.size {
+		return 0, fmt.Errorf("invalid startxref offset")
+	}
+	return offset, nil
+}
+
+func (d *document) loadXref() error {
+	offset, err := d.findStartXref()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[int64]bool)
+	for offset > 0 && !seen[offset] {
+		seen[offset] = true
+
+		trailer, err := d.readXrefSection(offset)
+		if err != nil {
+			return err
+		}
+		if d.trailer == nil {
+			d.trailer = trailer
+		}
+
+		// Hybrid files keep compressed objects in a separate xref stream
+		if stm, ok := trailer["XRefStm"].(int64); ok {
+			if _, err := d.readXrefSection(stm); err != nil {
+				return err
+			}
+		}
+
+		prev, ok := trailer["Prev"].(int64)
+		if !ok {
+			break
+		}
+		offset = prev
+	}
+
+	if d.trailer == nil {
+		return fmt.Errorf("no trailer found")
+	}
+	return nil
+}
+
+// readXrefSection reads either a classic xref table or an xref stream at the
+// given offset and returns its trailer dictionary. Entries from newer
+// sections, which are read first, take precedence.
+func (d *document) readXrefSection(offset int64) (dict, error) {
+	lx := d.readAt(offset)
+	token, err := lx.token()
+	if err != nil {
+		return nil, err
+	}
+	if token == keyword("xref") {
+		return d.readXrefTable(lx)
+	}
+	lx.unread(token)
+
+	obj, err := d.readIndirect(lx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading xref stream: %w", err)
+	}
+	s, ok := obj.(*stream)
+	if !ok || s.dict["Type"] != name("XRef") {
+		return nil, fmt.Errorf("no xref at offset %d", offset)
+	}
+	return s.dict, d.readXrefStream(s)
+}
+
+func (d *document) readXrefTable(lx *lexer) (dict, error) {
+	for {
+		token, err := lx.token()
+		if err != nil {
+			return nil, err
+		}
+		if token == keyword("trailer") {
+			trailer, err := lx.object()
+			if err != nil {
+				return nil, err
+			}
+			t, ok := trailer.(dict)
+			if !ok {
+				return nil, fmt.Errorf("invalid trailer")
+			}
+			return t, nil
+		}
+
+		start, ok := token.(int64)
+		if !ok {
+			return nil, fmt.Errorf("invalid xref subsection")
+		}
+		countToken, err := lx.token()
+		if err != nil {
+			return nil, err
+		}
+		count, ok := countToken.(int64)
+		if !ok {
+			return nil, fmt.Errorf("invalid xref subsection")
+		}
+
+		for i := int64(0); i < count; i++ {
+			var fields [3]any
+			for j := range fields {
+				if fields[j], err = lx.token(); err != nil {
+					return nil, err
+				}
+			}
+			offset, ok := fields[0].(int64)
+			if !ok {
+				return nil, fmt.Errorf("invalid xref entry")
+			}
+			num := int(start + i)
+			if _, exists := d.xref[num]; exists || fields[2] != keyword("n") {
+				continue
+			}
+			d.xref[num] = xrefEntry{offset: offset}
+		}
+	}
+}
+
+func (d *document) readXrefStream(s *stream) error {
+	data, err := d.decodeStream(s)
+	if err != nil {
+		return err
+	}
+
+	w, ok := s.dict["W"].(array)
+	if !ok || len(w) != 3 {
+		return fmt.Errorf("invalid xref stream /W")
+	}
+	var widths [3]int
+	rowSize := 0
+	for i := range widths {
+		width, ok := w[i].(int64)
+		if !ok || width < 0 || width > 8 {
+			return fmt.Errorf("invalid xref stream /W")
+		}
+		widths[i] = int(width)
+		rowSize += int(width)
+	}
+	if rowSize == 0 {
+		return fmt.Errorf("invalid xref stream /W")
+	}
+
+	index := array{int64(0), s.dict["Size"]}
+	if idx, ok := s.dict["Index"].(array); ok {
+		index = idx
+	}
+
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		start, ok1 := index[i].(int64)
+		count, ok2 := index[i+1].(int64)
+		if !ok1 || !ok2 {
+			return fmt.Errorf("invalid xref stream /Index")
+		}
+		for n := int64(0); n < count; n++ {
+			if pos+rowSize > len(data) {
+				return nil
+			}
+			row := data[pos : pos+rowSize]
+			pos += rowSize
+
+			var fields [3]int64
+			col := 0
+			for f, width := range widths {
+				for _, b := range row[col : col+width] {
+					fields[f] = fields[f]<<8 | int64(b)
+				}
+				col += width
+			}
+			// A missing type column defaults to 1
+			if widths[0] == 0 {
+				fields[0] = 1
+			}
+
+			num := int(start + n)
+			if _, exists := d.xref[num]; exists {
+				continue
+			}
+			switch fields[0] {
+			case 1:
+				d.xref[num] = xrefEntry{offset: fields[1]}
+			case 2:
+				d.xref[num] = xrefEntry{compressed: true, offset: fields[1], index: int(fields[2])}
+			}
+		}
+	}
+	return nil
+}
+
+var objPattern = regexp.MustCompile(`(?m)(\d+)\s+(\d+)\s+obj\b`)
+
+func (d *document) reconstructXref() error {
+	data := make([]byte, d.size)
+	if _, err := d.file.ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
+
+	for _, match := range objPattern.FindAllSubmatchIndex(data, -1) {
+		num, err := strconv.Atoi(string(data[match[2]:match[3]]))
+		if err != nil {
+			continue
+		}
+		// Later definitions win, as with incremental updates
+		d.xref[num] = xrefEntry{offset: int64(match[0])}
+	}
+	if len(d.xref) == 0 {
+		return fmt.Errorf("no objects found")
+	}
+
+	if i := bytes.LastIndex(data, []byte("trailer")); i >= 0 {
+		lx := newLexer(bytes.NewReader(data[i+len("trailer"):]))
+		if trailer, err := lx.object(); err == nil {
+			if t, ok := trailer.(dict); ok {
+				d.trailer = t
+			}
+		}
+	}
+	if d.trailer == nil {
+		d.trailer = make(dict)
+	}
+
+	// Cross-reference streams carry the trailer keys in their dictionary
+	if _, ok := d.trailer["Root"]; !ok {
+		for num := range d.xref {
+			obj := d.resolve(ref{num: num})
+			if s, ok := obj.(*stream); ok && s.dict["Type"] == name("XRef") {
+				for _, key := range []name{"Root", "Info", "Encrypt"} {
+					if value, ok := s.dict[key]; ok {
+						d.trailer[key] = value
+					}
+				}
+			}
+			if catalog, ok := obj.(dict); ok && catalog["Type"] == name("Catalog") {
+				d.trailer["Root"] = ref{num: num}
+			}
+		}
+	}
+	return nil
+}
+
+// readIndirect reads "num gen obj ... endobj" from the lexer.
+func (d *document) readIndirect(lx *lexer) (any, error) {
+	for _, expect := range []string{"num", "gen"} {
+		token, err := lx.token()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := token.(int64); !ok {
+			return nil, fmt.Errorf("%w: expected object %s", errUnexpectedToken, expect)
+		}
+	}
+	token, err := lx.token()
+	if err != nil {
+		return nil, err
+	}
+	if token != keyword("obj") {
+		return nil, fmt.Errorf("%w: expected obj", errUnexpectedToken)
+	}
+
+	obj, err := lx.object()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err = lx.token()
+	if err != nil || token != keyword("stream") {
+		return obj, nil
+	}
+	streamDict, ok := obj.(dict)
+	if !ok {
+		return nil, fmt.Errorf("stream without dictionary")
+	}
+
+	// The keyword is followed by CRLF or LF before the data
+	c, err := lx.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if c == '\r' {
+		if c, err = lx.r.ReadByte(); err != nil {
+			return nil, err
+		}
+	}
+	if c != '\n' {
+		lx.r.UnreadByte()
+	}
+
+	length, ok := d.resolve(streamDict["Length"]).(int64)
+	if !ok || length < 0 || length > d.size {
+		return nil, fmt.Errorf("invalid stream length")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(lx.r, data); err != nil {
+		return nil, fmt.Errorf("error reading stream: %w", err)
+	}
+	return &stream{dict: streamDict, data: data}, nil
+}
+
+func (d *document) resolve(obj any) any {
+	r, ok := obj.(ref)
+	if !ok {
+		return obj
+	}
+	if cached, ok := d.objects[r.num]; ok {
+		return cached
+	}
+	if d.depth > maxDepth {
+		return nil
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+
+	// Mark as in progress so cycles resolve to null
+	d.objects[r.num] = nil
+
+	entry, ok := d.xref[r.num]
+	if !ok {
+		return nil
+	}
+
+	var result any
+	if entry.compressed {
+		result = d.compressedObject(int(entry.offset), entry.index)
+	} else if entry.offset >= 0 && entry.offset < d.size {
+		if obj, err := d.readIndirect(d.readAt(entry.offset)); err == nil {
+			result = obj
+		}
+	}
+	d.objects[r.num] = result
+	return result
+}
+
+func (d *document) compressedObject(streamNum, index int) any {
+	s, ok := d.resolve(ref{num: streamNum}).(*stream)
+	if !ok || s.dict["Type"] != name("ObjStm") {
+		return nil
+	}
+	n, ok1 := s.dict["N"].(int64)
+	first, ok2 := s.dict["First"].(int64)
+	if !ok1 || !ok2 || n < 0 || first < 0 || index < 0 || int64(index) >= n {
+		return nil
+	}
+	data, err := d.decodeStream(s)
+	if err != nil || first > int64(len(data)) {
+		return nil
+	}
+
+	header := newLexer(bytes.NewReader(data[:first]))
+	var offset int64 = -1
+	for i := 0; i <= index; i++ {
+		num, err1 := header.token()
+		off, err2 := header.token()
+		if err1 != nil || err2 != nil {
+			return nil
+		}
+		if _, ok := num.(int64); !ok {
+			return nil
+		}
+		if i == index {
+			offset, _ = off.(int64)
+		}
+	}
+	if offset < 0 || first+offset > int64(len(data)) {
+		return nil
+	}
+
+	obj, err := newLexer(bytes.NewReader(data[first+offset:])).object()
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+func (d *document) decodeStream(s *stream) ([]byte, error) {
+	filters := d.resolve(s.dict["Filter"])
+	params := d.resolve(s.dict["DecodeParms"])
+
+	var filterList array
+	var paramList array
+	switch f := filters.(type) {
+	case nil:
+		return s.data, nil
+	case name:
+		filterList = array{f}
+		paramList = array{params}
+	case array:
+		filterList = f
+		if p, ok := params.(array); ok {
+			paramList = p
+		}
+	}
+
+	data := s.data
+	for i, filter := range filterList {
+		if d.resolve(filter) != name("FlateDecode") {
+			return nil, fmt.Errorf("unsupported stream filter %v", filter)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding stream: %w", err)
+		}
+		decoded, err := io.ReadAll(zr)
+		zr.Close()
+		// Truncated streams are common; keep what was decoded
+		if err != nil && len(decoded) == 0 {
+			return nil, fmt.Errorf("error decoding stream: %w", err)
+		}
+
+		var p dict
+		if i < len(paramList) {
+			p, _ = d.resolve(paramList[i]).(dict)
+		}
+		if data, err = applyPredictor(decoded, p); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// applyPredictor reverses the PNG row filters used by xref and object
+// streams.
+func applyPredictor(data []byte, params dict) ([]byte, error) {
+	predictor, _ := params["Predictor"].(int64)
+	if predictor < 10 {
+		if predictor > 1 {
+			return nil, fmt.Errorf("unsupported predictor %d", predictor)
+		}
+		return data, nil
+	}
+
+	columns := int64(1)
+	if c, ok := params["Columns"].(int64); ok && c > 0 {
+		columns = c
+	}
+	colors := int64(1)
+	if c, ok := params["Colors"].(int64); ok && c > 0 {
+		colors = c
+	}
+	bits := int64(8)
+	if b, ok := params["BitsPerComponent"].(int64); ok && b > 0 {
+		bits = b
+	}
+	// Colors and bits are small by the spec; a row can't be longer than
+	// the data, so bigger values only come from broken files
+	if colors > 32 || bits > 16 || columns > int64(len(data)) {
+		return nil, fmt.Errorf("invalid predictor parameters")
+	}
+	bpp := int((colors*bits + 7) / 8)
+	rowSize := int((columns*colors*bits + 7) / 8)
+	if rowSize > len(data) {
+		return nil, fmt.Errorf("invalid predictor parameters")
+	}
+
+	var out []byte
+	prev := make([]byte, rowSize)
+	for pos := 0; pos+rowSize+1 <= len(data); pos += rowSize + 1 {
+		filter := data[pos]
+		row := make([]byte, rowSize)
+		copy(row, data[pos+1:pos+1+rowSize])
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left = row[i-bpp]
+				upLeft = prev[i-bpp]
+			}
+			up := prev[i]
+			switch filter {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
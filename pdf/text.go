@@ -0,0 +1,130 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdfDocEncoding maps the bytes where PDFDocEncoding differs from Latin-1.
+var pdfDocEncoding = map[byte]rune{
+	0x18: '˘', 0x19: 'ˇ', 0x1A: 'ˆ', 0x1B: '˙',
+	0x1C: '˝', 0x1D: '˛', 0x1E: '˚', 0x1F: '˜',
+	0x80: '•', 0x81: '†', 0x82: '‡', 0x83: '…',
+	0x84: '—', 0x85: '–', 0x86: 'ƒ', 0x87: '⁄',
+	0x88: '‹', 0x89: '›', 0x8A: '−', 0x8B: '‰',
+	0x8C: '„', 0x8D: '“', 0x8E: '”', 0x8F: '‘',
+	0x90: '’', 0x91: '‚', 0x92: '™', 0x93: 'ﬁ',
+	0x94: 'ﬂ', 0x95: 'Ł', 0x96: 'Œ', 0x97: 'Š',
+	0x98: 'Ÿ', 0x99: 'Ž', 0x9A: 'ı', 0x9B: 'ł',
+	0x9C: 'œ', 0x9D: 'š', 0x9E: 'ž', 0xA0: '€',
+}
+
+// decodeText converts a PDF text string, which is either UTF-16BE with a
+// byte order mark, UTF-8 with a byte order mark (PDF 2.0) or PDFDocEncoding.
+func decodeText(s string) string {
+	b := []byte(s)
+	switch {
+	case len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF:
+		b = b[2:]
+		units := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return clean(string(utf16.Decode(units)))
+	case len(b) >= 3 && b[0] == 0xEF && b[1] == 0xBB && b[2] == 0xBF:
+		return clean(string(b[3:]))
+	}
+
+	var sb strings.Builder
+	for _, c := range b {
+		if r, ok := pdfDocEncoding[c]; ok {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune(rune(c))
+		}
+	}
+	return clean(sb.String())
+}
+
+func clean(s string) string {
+	// UTF-16 strings sometimes carry a trailing NUL
+	s = strings.Map(func(r rune) rune {
+		if r == 0 {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+const dcNamespace = "http://purl.org/dc/elements/1.1/"
+
+type xmpItem struct {
+	Lang  string `xml:"lang,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmpProperty struct {
+	Alt []xmpItem `xml:"Alt>li"`
+	Seq []xmpItem `xml:"Seq>li"`
+	Bag []xmpItem `xml:"Bag>li"`
+}
+
+func (p *xmpProperty) items() []xmpItem {
+	items := append([]xmpItem{}, p.Alt...)
+	items = append(items, p.Seq...)
+	return append(items, p.Bag...)
+}
+
+// parseXMP extracts dc:title and dc:creator from an XMP packet.
+func parseXMP(data []byte) (string, []string) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var title string
+	var creators []string
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Space != dcNamespace {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "title":
+			var prop xmpProperty
+			if decoder.DecodeElement(&prop, &start) != nil {
+				continue
+			}
+			for _, item := range prop.items() {
+				value := clean(item.Value)
+				if value == "" {
+					continue
+				}
+				if title == "" || item.Lang == "x-default" {
+					title = value
+				}
+			}
+		case "creator":
+			var prop xmpProperty
+			if decoder.DecodeElement(&prop, &start) != nil {
+				continue
+			}
+			for _, item := range prop.items() {
+				if value := clean(item.Value); value != "" {
+					creators = append(creators, value)
+				}
+			}
+		}
+	}
+	return title, creators
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"switcher/library"
+)
+
+// ScanDone is sent with the library:scan-done event when a scan finishes,
+// fails or is cancelled
+type ScanDone struct {
+	Report    library.ScanReport `json:"report"`
+	Error     string             `json:"error,omitempty"`
+	Cancelled bool               `json:"cancelled"`
+}
+
+// startScan runs a library scan in the background, reporting progress
+// through library:scan-progress events
+func (a *App) startScan() {
+	go func() {
+		if _, err := a.runScan(); err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Printf("Failed to scan books directory: %v\n", err)
+		}
+	}()
+}
+
+// runScan scans the library and returns when the scan is done. Only one scan
+// runs at a time; CancelScan stops it
+func (a *App) runScan() (library.ScanReport, error) {
+	if a.library == nil {
+		return library.ScanReport{}, fmt.Errorf("library not initialized")
+	}
+
+	a.scanMu.Lock()
+	if a.scanCancel != nil {
+		a.scanMu.Unlock()
+		return library.ScanReport{}, fmt.Errorf("a library scan is already running")
+	}
+	ctx, cancel := context.WithCancel(a.ctx)
+	done := make(chan struct{})
+	a.scanCancel = cancel
+	a.scanDone = done
+	a.scanMu.Unlock()
+
+	defer func() {
+		a.scanMu.Lock()
+		a.scanCancel = nil
+		a.scanDone = nil
+		a.scanMu.Unlock()
+		cancel()
+		close(done)
+	}()
+
+	for _, root := range a.library.Roots {
+		fmt.Printf("Starting book library scan from: %s\n", root.Path)
+	}
+	report, err := a.library.Scan(ctx, func(progress library.ScanProgress) {
+		runtime.EventsEmit(a.ctx, "library:scan-progress", progress)
+	})
+
+	result := ScanDone{Report: report, Cancelled: errors.Is(err, context.Canceled)}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		fmt.Printf("Book library scan completed: %v\n", report)
+	}
+	runtime.EventsEmit(a.ctx, "library:scan-done", result)
+	return report, err
+}
+
+// CancelScan stops the running library scan. Books already written stay in
+// the library. It reports whether a scan was running
+func (a *App) CancelScan() bool {
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
+	if a.scanCancel == nil {
+		return false
+	}
+	a.scanCancel()
+	return true
+}
+
+// cancelScanAndWait stops the running scan, if any, and waits until it has
+// rolled back
+func (a *App) cancelScanAndWait() {
+	a.scanMu.Lock()
+	done := a.scanDone
+	if a.scanCancel != nil {
+		a.scanCancel()
+	}
+	a.scanMu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
@@ -0,0 +1,222 @@
+package sioyek
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"switcher/util"
+)
+
+func GetDataPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".local/share/sioyek"), nil
+}
+
+// Sioyek reads the databases sioyek keeps in its data directory: local.db
+// maps file paths to the hashes of their contents, and shared.db keeps the
+// positions, marks and highlights of each hash so they can be synced
+// between machines. Old versions keep everything in local.db, by path.
+type Sioyek struct {
+	Local  *sql.DB
+	Shared *sql.DB
+	// LocalPath and SharedPath are empty when the database does not exist
+	LocalPath  string
+	SharedPath string
+}
+
+// Open reads the databases in dir.
+func Open(dir string) (*Sioyek, error) {
+	s := &Sioyek{}
+	for _, db := range []struct {
+		file string
+		db   **sql.DB
+		path *string
+	}{{"local.db", &s.Local, &s.LocalPath}, {"shared.db", &s.Shared, &s.SharedPath}} {
+		path := filepath.Join(dir, db.file)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		conn, err := util.LoadDatabase(path)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		*db.db, *db.path = conn, path
+	}
+	if s.Local == nil && s.Shared == nil {
+		return nil, fmt.Errorf("no sioyek database in %s", dir)
+	}
+	return s, nil
+}
+
+func (s *Sioyek) Close() error {
+	var err error
+	for _, db := range []*sql.DB{s.Local, s.Shared} {
+		if db != nil {
+			if closeErr := db.Close(); closeErr != nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+type BookInfo struct {
+	Filename string `json:"filename"`
+	// OffsetY is the position in the document, in points from the top of
+	// the first page, as sioyek shows pages one below the other
+	OffsetY    float64 `json:"offsetY"`
+	Marks      int     `json:"marks,omitempty"`
+	Highlights int     `json:"highlights,omitempty"`
+	// LastRead is when sioyek last saved the position, in unix seconds
+	LastRead int64 `json:"lastRead,omitempty"`
+}
+
+func (s *Sioyek) GetAllKnownBooks() (map[string]BookInfo, error) {
+	paths, err := s.hashPaths()
+	if err != nil {
+		return nil, err
+	}
+	// Rows are keyed by hash, or by path in old databases
+	pathOf := func(key string) string {
+		if path, ok := paths[key]; ok {
+			return path
+		}
+		if filepath.IsAbs(key) {
+			return key
+		}
+		return ""
+	}
+
+	books := make(map[string]BookInfo)
+	for _, db := range s.databases() {
+		// sioyek stores time as datetime('now'), in UTC
+		err := s.eachRow(db, `SELECT path, offset_y, CAST(strftime('%s', last_access_time) AS INTEGER) FROM opened_books`,
+			func(rows *sql.Rows) error {
+				var key string
+				var offsetY float64
+				var lastRead sql.NullInt64
+				if err := rows.Scan(&key, &offsetY, &lastRead); err != nil {
+					return err
+				}
+				path := pathOf(key)
+				if path == "" {
+					return nil
+				}
+				if book, ok := books[path]; ok && book.LastRead > lastRead.Int64 {
+					return nil
+				}
+				books[path] = BookInfo{Filename: path, OffsetY: offsetY, LastRead: lastRead.Int64}
+				return nil
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, count := range []struct {
+		table string
+		add   func(*BookInfo, int)
+	}{
+		{"marks", func(b *BookInfo, n int) { b.Marks += n }},
+		{"highlights", func(b *BookInfo, n int) { b.Highlights += n }},
+	} {
+		for _, db := range s.databases() {
+			err := s.eachRow(db, `SELECT document_path, COUNT(*) FROM `+count.table+` GROUP BY document_path`,
+				func(rows *sql.Rows) error {
+					var key string
+					var n int
+					if err := rows.Scan(&key, &n); err != nil {
+						return err
+					}
+					if book, ok := books[pathOf(key)]; ok {
+						count.add(&book, n)
+						books[book.Filename] = book
+					}
+					return nil
+				})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for path := range books {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			log.Printf("File does not exist: %s", path)
+			delete(books, path)
+		}
+	}
+	return books, nil
+}
+
+// hashPaths maps the hash of every file sioyek opened to its path.
+func (s *Sioyek) hashPaths() (map[string]string, error) {
+	paths := make(map[string]string)
+	if s.Local == nil {
+		return paths, nil
+	}
+	err := s.eachRow(s.Local, `SELECT path, hash FROM document_hash`, func(rows *sql.Rows) error {
+		var path, hash string
+		if err := rows.Scan(&path, &hash); err != nil {
+			return err
+		}
+		paths[hash] = path
+		return nil
+	})
+	return paths, err
+}
+
+func (s *Sioyek) databases() []*sql.DB {
+	var dbs []*sql.DB
+	for _, db := range []*sql.DB{s.Local, s.Shared} {
+		if db != nil {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs
+}
+
+// eachRow runs query and calls fn for every row. Tables that the version of
+// sioyek which wrote db does not have are skipped.
+func (s *Sioyek) eachRow(db *sql.DB, query string, fn func(*sql.Rows) error) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return nil
+		}
+		return fmt.Errorf("error querying database: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			log.Printf("Error scanning row: %v", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
+	}
+	return nil
+}
+
+// PageOf returns the 1-based page shown at offsetY, given the height of
+// every page.
+func PageOf(offsetY float64, heights []float64) int {
+	top := 0.0
+	for i, height := range heights {
+		top += height
+		if offsetY < top {
+			return i + 1
+		}
+	}
+	return len(heights)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"switcher/library"
+	"switcher/stats"
+	"switcher/util"
+)
+
+// runStatsCommand handles `switcher stats` and exits
+func runStatsCommand(args []string) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	from := flags.String("from", "", "first day, YYYY-MM-DD (default 30 days before --to)")
+	to := flags.String("to", "", "last day, YYYY-MM-DD (default today)")
+	tz := flags.String("tz", "", "time zone days start in, e.g. Europe/Berlin (default local)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: switcher stats [flags]")
+		fmt.Fprintln(flags.Output(), "\nPrints reading statistics from the sessions of books opened in switcher.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	loc := time.Local
+	if *tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(*tz); err != nil {
+			fmt.Printf("❌ Unknown time zone: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	first, last, err := stats.ParseRange(*from, *to, loc)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(2)
+	}
+
+	dbPath, err := library.GetLibraryDatabasePath()
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	db, err := util.LoadDatabase(dbPath)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	if status, err := library.GetSchemaStatus(db); err != nil || len(status.Pending) > 0 {
+		fmt.Println("❌ The library database is not up to date, run `switcher db migrate` first")
+		os.Exit(1)
+	}
+
+	report, err := stats.Compute(db, first, last, loc)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	printStats(report)
+}
+
+func printStats(r *stats.Report) {
+	fmt.Printf("Reading from %s to %s (%s)\n\n", r.From, r.To, r.TimeZone)
+	fmt.Printf("%d sessions, %s, %d pages\n", r.Sessions, formatSeconds(r.Seconds), r.Pages)
+	fmt.Printf("Current streak: %s", formatDays(r.Streaks.Current))
+	if r.Streaks.Longest > 0 {
+		fmt.Printf(", longest: %s (%s to %s)", formatDays(r.Streaks.Longest), r.Streaks.LongestStart, r.Streaks.LongestEnd)
+	}
+	fmt.Println()
+
+	if r.Sessions == 0 {
+		return
+	}
+
+	fmt.Println("\nPer day:")
+	var longest int64
+	for _, day := range r.Days {
+		longest = max(longest, day.Seconds)
+	}
+	for _, day := range r.Days {
+		if day.Seconds == 0 {
+			continue
+		}
+		bar := strings.Repeat("█", max(1, int(day.Seconds*30/longest)))
+		fmt.Printf("  %s %10s %5d pages  %s\n", day.Date, formatSeconds(day.Seconds), day.Pages, bar)
+	}
+
+	if len(r.Finished) > 0 {
+		fmt.Println("\nFinished:")
+		for _, month := range r.Finished {
+			fmt.Printf("  %s  %d: %s\n", month.Month, len(month.Books), strings.Join(month.Books, ", "))
+		}
+	}
+
+	if len(r.Formats) > 0 {
+		fmt.Println("\nSpeed:")
+		for _, speed := range r.Formats {
+			fmt.Printf("  %-8s %6.1f pages/hour  (%d pages in %s)\n", speed.Format, speed.PagesPerHour, speed.Pages, formatSeconds(speed.Seconds))
+		}
+	}
+
+	fmt.Println("\nMost read authors:")
+	for _, author := range r.Authors {
+		fmt.Printf("  %-30s %10s %5d pages  %d books\n", author.Author, formatSeconds(author.Seconds), author.Pages, author.Books)
+	}
+}
+
+func formatSeconds(seconds int64) string {
+	d := time.Duration(seconds) * time.Second
+	if d < time.Hour {
+		return fmt.Sprintf("%d min", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%d h %02d min", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func formatDays(days int) string {
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
@@ -0,0 +1,377 @@
+package stats
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Report summarises the reading sessions in a range of days. Times are in
+// seconds, days and months are in the time zone the report was made for.
+type Report struct {
+	From     string `json:"from"` // 2006-01-02
+	To       string `json:"to"`   // last day, inclusive
+	TimeZone string `json:"timeZone"`
+
+	Sessions int   `json:"sessions"`
+	Seconds  int64 `json:"seconds"`
+	Pages    int   `json:"pages"`
+
+	Days     []Day      `json:"days"`
+	Finished []Month    `json:"finished"`
+	Formats  []Speed    `json:"formats"`
+	Authors  []Author   `json:"authors"`
+	Streaks  StreakInfo `json:"streaks"`
+}
+
+// Day is the reading of one day, every day of the range included.
+type Day struct {
+	Date    string `json:"date"`
+	Seconds int64  `json:"seconds"`
+	Pages   int    `json:"pages"`
+}
+
+// Month lists the books finished in a month, by the session that read them
+// to the end.
+type Month struct {
+	Month string   `json:"month"` // 2006-01
+	Books []string `json:"books"`
+}
+
+// Speed is how fast books of a format are read, from sessions that moved
+// forward.
+type Speed struct {
+	Format       string  `json:"format"`
+	Seconds      int64   `json:"seconds"`
+	Pages        int     `json:"pages"`
+	PagesPerHour float64 `json:"pagesPerHour"`
+}
+
+// Author is the reading of the books of one author.
+type Author struct {
+	Author  string `json:"author"`
+	Seconds int64  `json:"seconds"`
+	Pages   int    `json:"pages"`
+	Books   int    `json:"books"`
+}
+
+// StreakInfo counts days in a row with any reading. The current streak
+// ends on the last day of the range, or the day before when nothing was
+// read on it yet. Streaks look at sessions before the range too.
+type StreakInfo struct {
+	Current      int    `json:"current"`
+	Longest      int    `json:"longest"`
+	LongestStart string `json:"longestStart,omitempty"`
+	LongestEnd   string `json:"longestEnd,omitempty"`
+}
+
+// MaxAuthors is how many of the most read authors a report lists.
+const MaxAuthors = 10
+
+const dateLayout = "2006-01-02"
+
+type session struct {
+	path      string
+	title     string
+	author    string
+	format    string
+	start     time.Time
+	end       time.Time
+	pages     int
+	finished  bool
+	startPage int
+}
+
+// Compute builds the report for the days from from to to, both inclusive,
+// in loc. Sessions crossing midnight count on both days, their pages split
+// by time. Sessions still running count until now.
+func Compute(db *sql.DB, from, to time.Time, loc *time.Location) (*Report, error) {
+	first := startOfDay(from, loc)
+	last := startOfDay(to, loc)
+	if last.Before(first) {
+		return nil, fmt.Errorf("range ends on %s, before it starts on %s", last.Format(dateLayout), first.Format(dateLayout))
+	}
+	end := last.AddDate(0, 0, 1)
+
+	sessions, err := loadSessions(db, end)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{From: first.Format(dateLayout), To: last.Format(dateLayout), TimeZone: loc.String()}
+	seconds := make(map[string]float64)
+	pages := make(map[string]float64)
+	formats := make(map[string]*Speed)
+	authors := make(map[string]*Author)
+	authorBooks := make(map[string]map[string]bool)
+	finishedAt := make(map[string]time.Time)
+	readDays := make(map[string]bool)
+
+	for _, s := range sessions {
+		// Streaks and first finishes look at all of the history
+		for _, span := range splitDays(s.start, s.end, loc) {
+			if span.seconds > 0 {
+				readDays[span.date] = true
+			}
+			seconds[span.date] += span.seconds
+			if total := s.end.Sub(s.start).Seconds(); total > 0 {
+				pages[span.date] += float64(s.pages) * span.seconds / total
+			}
+		}
+		// A book opened again after it was finished ends finished too, but
+		// only the session that read up to the end finished it
+		if s.finished && s.pages > 0 {
+			if at, ok := finishedAt[s.path]; !ok || s.end.Before(at) {
+				finishedAt[s.path] = s.end
+			}
+		}
+
+		if !s.end.After(first) || !s.start.Before(end) {
+			continue
+		}
+		// Only the part inside the range counts
+		inRange := clip(s.start, s.end, first, end)
+		share := 1.0
+		if total := s.end.Sub(s.start).Seconds(); total > 0 {
+			share = inRange.Seconds() / total
+		}
+		sessionPages := int(math.Round(float64(s.pages) * share))
+		report.Sessions++
+		report.Seconds += int64(inRange.Seconds())
+		report.Pages += sessionPages
+
+		if s.pages > 0 {
+			speed := formats[s.format]
+			if speed == nil {
+				speed = &Speed{Format: s.format}
+				formats[s.format] = speed
+			}
+			speed.Seconds += int64(inRange.Seconds())
+			speed.Pages += sessionPages
+		}
+
+		author := authors[s.author]
+		if author == nil {
+			author = &Author{Author: s.author}
+			authors[s.author] = author
+			authorBooks[s.author] = make(map[string]bool)
+		}
+		author.Seconds += int64(inRange.Seconds())
+		author.Pages += sessionPages
+		authorBooks[s.author][s.path] = true
+	}
+
+	for day := first; day.Before(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format(dateLayout)
+		report.Days = append(report.Days, Day{Date: date, Seconds: int64(seconds[date]), Pages: int(math.Round(pages[date]))})
+	}
+	report.Finished = finishedByMonth(sessions, finishedAt, first, end, loc)
+	report.Formats = sortedSpeeds(formats)
+	report.Authors = topAuthors(authors, authorBooks)
+	report.Streaks = streaks(readDays, last, loc)
+	return report, nil
+}
+
+// loadSessions reads the sessions that started before end with their books.
+// Sessions of books no longer in the library keep their file name.
+func loadSessions(db *sql.DB, end time.Time) ([]session, error) {
+	rows, err := db.Query(`
+		SELECT s.filepath, coalesce(b.title, ''), coalesce(b.author, ''), coalesce(b.format, ''),
+			s.started_at, s.ended_at, s.start_page, coalesce(s.end_page, s.start_page), s.end_finished
+		FROM reading_sessions s
+		LEFT JOIN books b ON b.filepath = s.filepath
+		WHERE s.started_at < ?
+		ORDER BY s.started_at`, end.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("error querying reading sessions: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var sessions []session
+	for rows.Next() {
+		var s session
+		var startedAt int64
+		var endedAt sql.NullInt64
+		var endPage int
+		err := rows.Scan(&s.path, &s.title, &s.author, &s.format, &startedAt, &endedAt, &s.startPage, &endPage, &s.finished)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning reading session: %w", err)
+		}
+		s.start = time.Unix(startedAt, 0)
+		s.end = now
+		if endedAt.Valid {
+			s.end = time.Unix(endedAt.Int64, 0)
+		}
+		if s.end.Before(s.start) {
+			s.end = s.start
+		}
+		// Going back, e.g. to reread a chapter, does not count as reading
+		s.pages = max(0, endPage-s.startPage)
+		if s.title == "" {
+			s.title = filepath.Base(s.path)
+		}
+		if s.author == "" {
+			s.author = "Unknown"
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reading sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+type daySpan struct {
+	date    string
+	seconds float64
+}
+
+// splitDays splits the time from start to end at midnight in loc.
+func splitDays(start, end time.Time, loc *time.Location) []daySpan {
+	var spans []daySpan
+	for day := startOfDay(start, loc); ; day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		spans = append(spans, daySpan{date: day.Format(dateLayout), seconds: clip(start, end, day, next).Seconds()})
+		if !next.Before(end) {
+			break
+		}
+	}
+	return spans
+}
+
+// clip returns how much of start to end lies between from and to.
+func clip(start, end, from, to time.Time) time.Duration {
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+func finishedByMonth(sessions []session, finishedAt map[string]time.Time, first, end time.Time, loc *time.Location) []Month {
+	titles := make(map[string]string)
+	for _, s := range sessions {
+		titles[s.path] = s.title
+	}
+	byMonth := make(map[string][]string)
+	for path, at := range finishedAt {
+		if at.Before(first) || !at.Before(end) {
+			continue
+		}
+		month := at.In(loc).Format("2006-01")
+		byMonth[month] = append(byMonth[month], titles[path])
+	}
+
+	months := make([]Month, 0, len(byMonth))
+	for month, books := range byMonth {
+		sort.Strings(books)
+		months = append(months, Month{Month: month, Books: books})
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Month < months[j].Month })
+	return months
+}
+
+func sortedSpeeds(formats map[string]*Speed) []Speed {
+	speeds := make([]Speed, 0, len(formats))
+	for _, speed := range formats {
+		if speed.Seconds > 0 {
+			speed.PagesPerHour = float64(speed.Pages) / (float64(speed.Seconds) / 3600)
+		}
+		speeds = append(speeds, *speed)
+	}
+	sort.Slice(speeds, func(i, j int) bool { return speeds[i].Seconds > speeds[j].Seconds })
+	return speeds
+}
+
+func topAuthors(authors map[string]*Author, books map[string]map[string]bool) []Author {
+	list := make([]Author, 0, len(authors))
+	for name, author := range authors {
+		author.Books = len(books[name])
+		list = append(list, *author)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Seconds != list[j].Seconds {
+			return list[i].Seconds > list[j].Seconds
+		}
+		return list[i].Author < list[j].Author
+	})
+	if len(list) > MaxAuthors {
+		list = list[:MaxAuthors]
+	}
+	return list
+}
+
+// streaks finds the current streak as of last and the longest one up to it.
+func streaks(read map[string]bool, last time.Time, loc *time.Location) StreakInfo {
+	days := make([]string, 0, len(read))
+	for day := range read {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	var info StreakInfo
+	lastDate := last.Format(dateLayout)
+	run := 0
+	var runStart, previous time.Time
+	for _, date := range days {
+		if date > lastDate {
+			break
+		}
+		day, _ := time.ParseInLocation(dateLayout, date, loc)
+		if run > 0 && previous.AddDate(0, 0, 1).Equal(day) {
+			run++
+		} else {
+			run, runStart = 1, day
+		}
+		previous = day
+		if run > info.Longest {
+			info.Longest = run
+			info.LongestStart = runStart.Format(dateLayout)
+			info.LongestEnd = date
+		}
+	}
+
+	day := last
+	if !read[day.Format(dateLayout)] {
+		day = day.AddDate(0, 0, -1)
+	}
+	for read[day.Format(dateLayout)] {
+		info.Current++
+		day = day.AddDate(0, 0, -1)
+	}
+	return info
+}
+
+// ParseRange reads the first and last day of a report, as 2006-01-02 in
+// loc. An empty to is today and an empty from the 30 days up to to.
+func ParseRange(from, to string, loc *time.Location) (time.Time, time.Time, error) {
+	last := time.Now().In(loc)
+	if to != "" {
+		var err error
+		if last, err = time.ParseInLocation(dateLayout, to, loc); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end date %q, expected YYYY-MM-DD", to)
+		}
+	}
+	first := startOfDay(last, loc).AddDate(0, 0, -29)
+	if from != "" {
+		var err error
+		if first, err = time.ParseInLocation(dateLayout, from, loc); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid start date %q, expected YYYY-MM-DD", from)
+		}
+	}
+	return first, last, nil
+}
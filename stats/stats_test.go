@@ -0,0 +1,94 @@
+package stats
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"switcher/library"
+	"switcher/util"
+)
+
+func TestComputeAcrossMidnight(t *testing.T) {
+	db, err := util.LoadDatabase(filepath.Join(t.TempDir(), "library.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := library.Migrate(db, library.MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	at := func(day, hour, minute int) int64 {
+		return time.Date(2026, time.March, day, hour, minute, 0, 0, loc).Unix()
+	}
+	_, err = db.Exec(`
+		INSERT INTO books (filepath, title, author, format) VALUES ('/books/dune.pdf', 'Dune', 'Frank Herbert', 'pdf');
+		INSERT INTO reading_sessions (filepath, pid, started_at, ended_at, start_page, end_page, end_finished)
+		VALUES ('/books/dune.pdf', 1, ?, ?, 10, 50, 0),
+			('/books/dune.pdf', 2, ?, ?, 50, 60, 1),
+			('/books/gone.epub', 3, ?, ?, 5, 3, 0)`,
+		at(9, 23, 0), at(10, 1, 0),
+		at(11, 10, 0), at(11, 10, 30),
+		at(11, 12, 0), at(11, 12, 15))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Date(2026, time.March, 10, 0, 0, 0, 0, loc)
+	to := time.Date(2026, time.March, 11, 0, 0, 0, 0, loc)
+	report, err := Compute(db, from, to, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first session counts an hour and half of its pages on the 10th
+	if report.Sessions != 3 || report.Seconds != 3600+1800+900 || report.Pages != 20+10 {
+		t.Errorf("totals = %d sessions, %d s, %d pages", report.Sessions, report.Seconds, report.Pages)
+	}
+	wantDays := []Day{
+		{Date: "2026-03-10", Seconds: 3600, Pages: 20},
+		{Date: "2026-03-11", Seconds: 1800 + 900, Pages: 10},
+	}
+	if !reflect.DeepEqual(report.Days, wantDays) {
+		t.Errorf("Days = %+v, want %+v", report.Days, wantDays)
+	}
+	wantFinished := []Month{{Month: "2026-03", Books: []string{"Dune"}}}
+	if !reflect.DeepEqual(report.Finished, wantFinished) {
+		t.Errorf("Finished = %+v, want %+v", report.Finished, wantFinished)
+	}
+	// Going back a few pages does not count towards the speed
+	wantFormats := []Speed{{Format: "pdf", Seconds: 5400, Pages: 30, PagesPerHour: 20}}
+	if !reflect.DeepEqual(report.Formats, wantFormats) {
+		t.Errorf("Formats = %+v, want %+v", report.Formats, wantFormats)
+	}
+	wantAuthors := []Author{
+		{Author: "Frank Herbert", Seconds: 5400, Pages: 30, Books: 1},
+		{Author: "Unknown", Seconds: 900, Books: 1},
+	}
+	if !reflect.DeepEqual(report.Authors, wantAuthors) {
+		t.Errorf("Authors = %+v, want %+v", report.Authors, wantAuthors)
+	}
+	// The 9th is before the range but still part of the streak
+	wantStreaks := StreakInfo{Current: 3, Longest: 3, LongestStart: "2026-03-09", LongestEnd: "2026-03-11"}
+	if report.Streaks != wantStreaks {
+		t.Errorf("Streaks = %+v, want %+v", report.Streaks, wantStreaks)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	loc := time.UTC
+	first, last, err := ParseRange("2026-01-01", "2026-01-31", loc)
+	if err != nil || first.Format(dateLayout) != "2026-01-01" || last.Format(dateLayout) != "2026-01-31" {
+		t.Errorf("ParseRange = %v, %v, %v", first, last, err)
+	}
+	first, last, err = ParseRange("", "2026-03-30", loc)
+	if err != nil || first.Format(dateLayout) != "2026-03-01" || last.Format(dateLayout) != "2026-03-30" {
+		t.Errorf("ParseRange without from = %v, %v, %v", first, last, err)
+	}
+	if _, _, err := ParseRange("yesterday", "", loc); err == nil {
+		t.Error("ParseRange accepted an invalid date")
+	}
+}
@@ -21,7 +21,8 @@ func GetDatabasePath() (string, error) {
 }
 
 type Zathura struct {
-	DB *sql.DB
+	DB   *sql.DB
+	Path string
 }
 
 func NewZathura() (*Zathura, error) {
@@ -29,20 +30,32 @@ func NewZathura() (*Zathura, error) {
 	if err != nil {
 		return nil, err
 	}
+	return Open(path)
+}
+
+// Open reads the bookmarks database at path.
+func Open(path string) (*Zathura, error) {
 	db, err := util.LoadDatabase(path)
 	if err != nil {
 		return nil, err
 	}
-	return &Zathura{DB: db}, nil
+	return &Zathura{DB: db, Path: path}, nil
 }
 
 type BookInfo struct {
 	Filename string `json:"filename"`
 	Page     int    `json:"page"`
+	// LastRead is when zathura last closed the file, in unix seconds
+	LastRead int64 `json:"lastRead,omitempty"`
 }
 
 func (zat *Zathura) GetAllKnownBooks() (map[string]BookInfo, error) {
-	rows, err := zat.DB.Query("SELECT file, page FROM fileinfo")
+	// zathura stores time as DATETIME('now'), in UTC
+	rows, err := zat.DB.Query(`SELECT file, page, CAST(strftime('%s', time) AS INTEGER) FROM fileinfo`)
+	if err != nil {
+		// Databases of old zathura versions have no time column
+		rows, err = zat.DB.Query("SELECT file, page, NULL FROM fileinfo")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error querying database: %w", err)
 	}
@@ -52,7 +65,8 @@ func (zat *Zathura) GetAllKnownBooks() (map[string]BookInfo, error) {
 	for rows.Next() {
 		var filePath string
 		var page int
-		err := rows.Scan(&filePath, &page)
+		var lastRead sql.NullInt64
+		err := rows.Scan(&filePath, &page, &lastRead)
 		if err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
@@ -66,6 +80,7 @@ func (zat *Zathura) GetAllKnownBooks() (map[string]BookInfo, error) {
 		bookmark := BookInfo{
 			Filename: filePath,
 			Page:     page,
+			LastRead: lastRead.Int64,
 		}
 
 		bookmarks[filePath] = bookmark